@@ -1,10 +1,10 @@
 package ab
 
 import (
-	"crypto/sha256"
-	"encoding/binary"
+	"errors"
 	"fmt"
-	"math/rand"
+	"sync"
+	"time"
 
 	"github.com/calebhiebert/gobbl"
 )
@@ -23,12 +23,66 @@ type Test struct {
 	// Probability is a []float of numbers between 0 and 1 that notes the probability of
 	// each variation ocurring. If Probability is nil, each variation has an equal chance
 	Probability []float64
+
+	// Salt is an optional value mixed into the bucketing hash. Changing the salt
+	// will reshuffle every user of this test without affecting any other test
+	Salt string
+
+	// Rules are checked against the context before a user is enrolled, every rule
+	// must pass for the user to receive a variation
+	Rules []TargetingRule
+
+	// Allocation is a number between 0 and 1 that notes the fraction of users that
+	// are enrolled in the test. If Allocation is 0, every user is enrolled.
+	// For tests in a layer, Allocation is the test's share of the layer
+	Allocation float64
+
+	// Layer is an optional name that makes this test mutually exclusive with every
+	// other test in the same layer, a user is enrolled in at most one of them
+	Layer string
+
+	// Factors turn the test into a multivariate test, a variation is generated for
+	// every combination of factor levels. Variations should be nil when Factors are used
+	Factors []Factor
+
+	// Control is the handler users that are not enrolled in the test receive.
+	// If Control is nil, the first variation is used
+	Control gbl.MiddlewareFunction
+
+	// Mode is how new users are allocated to variations. By default (Fixed) the
+	// Probability weights are used, the adaptive modes use the conversions of Goal
+	// to shift traffic towards the best performing variations
+	Mode AllocationMode
+
+	// Goal is the conversion goal adaptive modes optimize for
+	Goal string
+
+	// Epsilon is the fraction of users that explore a random variation in EpsilonGreedy mode.
+	// If Epsilon is 0, 0.1 is used
+	Epsilon float64
+
+	// Status is the lifecycle stage of the test, if Status is blank the test is running.
+	// Only running tests enroll users, everyone receives the control otherwise
+	Status Status
+
+	// Start and End optionally limit the time the test is running, zero values are ignored
+	Start time.Time
+	End   time.Time
+
+	// Winner is the variation everyone receives once the test is concluded,
+	// or NotEnrolled to send everyone to the control
+	Winner int
 }
 
 // Tester stores all the AB tests for a single project
 type Tester struct {
-	testArr []Test
-	idgen   IDGenFunc
+	testArr   []Test
+	idgen     IDGenFunc
+	store     AssignmentStore
+	sink      EventSink
+	fallback  gbl.MiddlewareFunction
+	overrides map[string]TestSuite
+	mutex     *sync.RWMutex
 }
 
 // TestList is a list of gobbl handlers to be treated as variations for a test
@@ -38,171 +92,434 @@ type TestList []gbl.MiddlewareFunction
 type IDGenFunc func(c *gbl.Context) string
 
 // TestSuite is a map of test types with the value being the test variation
-// chosen for the user
+// chosen for the user, or NotEnrolled if the user is outside of the test's allocation
 type TestSuite map[string]int
 
+// NotEnrolled is the variation stored in a TestSuite for tests the user is not enrolled in
+const NotEnrolled = -1
+
 // New will create a new ABTester instance
 func New() *Tester {
 	return &Tester{
-		testArr: []Test{},
+		testArr:   []Test{},
+		overrides: make(map[string]TestSuite),
+		mutex:     &sync.RWMutex{},
 	}
 }
 
 // NewCustom returns a tester that uses a custom
 func NewCustom(idgen IDGenFunc) *Tester {
 	return &Tester{
-		testArr: []Test{},
-		idgen:   idgen,
+		testArr:   []Test{},
+		idgen:     idgen,
+		overrides: make(map[string]TestSuite),
+		mutex:     &sync.RWMutex{},
 	}
 }
 
-// Register will register a new AB test
+// UseStore will make the tester persist each user's assignments in the given store.
+// Once a user has been exposed to a test, they will keep receiving the same variation
+// even if tests are added or their probabilities change.
+// If no store is set when an adaptive test is registered, a memory store is used
+func (t *Tester) UseStore(store AssignmentStore) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.store = store
+}
+
+// UseFallback sets the handler that is called when AB is asked for a test type that
+// was never registered. If no fallback is set, the request continues down the middleware chain
+func (t *Tester) UseFallback(fallback gbl.MiddlewareFunction) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.fallback = fallback
+}
+
+// Register will register a new AB test, it panics if any of the tests are invalid
 func (t *Tester) Register(tests ...Test) {
-	for _, test := range tests {
-		if test.Variations == nil {
-			panic("no variations supplied")
+	err := t.RegisterE(tests...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// RegisterE will register new AB tests, returning an error if any of the tests
+// are invalid. No tests are registered if an error is returned
+func (t *Tester) RegisterE(tests ...Test) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	types := make(map[string]bool)
+
+	for _, test := range t.testArr {
+		types[test.Type] = true
+	}
+
+	prepared := make([]Test, len(tests))
+
+	for i, test := range tests {
+		if len(test.Factors) > 0 && test.Variations == nil {
+			test.Variations = combineFactors(test.Factors)
 		}
 
-		if test.Type == "" {
-			panic("cannot supply blank test type")
+		err := test.Validate()
+		if err != nil {
+			return err
+		}
+
+		prepared[i] = test
+
+		if types[test.Type] {
+			return fmt.Errorf("test type %s was already registered", test.Type)
+		}
+
+		types[test.Type] = true
+	}
+
+	updated := append(append([]Test{}, t.testArr...), prepared...)
+
+	err := validateLayers(updated)
+	if err != nil {
+		return err
+	}
+
+	for _, test := range prepared {
+		// Adaptive tests need somewhere to keep users sticky
+		if test.Mode != Fixed && t.store == nil {
+			t.store = MemoryStore()
+		}
+	}
+
+	t.testArr = updated
+
+	return nil
+}
+
+// SetProbability will change the probabilities of a registered test at runtime.
+// Users that have already been recorded in the assignment store keep their variation
+func (t *Tester) SetProbability(testType string, probability []float64) error {
+	if probability != nil {
+		probability = append([]float64{}, probability...)
+	}
+
+	return t.updateTest(testType, func(test *Test) {
+		test.Probability = probability
+	})
+}
+
+// Validate returns an error if the test is not configured correctly
+func (test Test) Validate() error {
+	if test.Type == "" {
+		return errors.New("cannot supply blank test type")
+	}
+
+	err := test.validateFactors()
+	if err != nil {
+		return err
+	}
+
+	if len(test.Variations) == 0 {
+		return fmt.Errorf("no variations supplied for test %s", test.Type)
+	}
+
+	for i, variation := range test.Variations {
+		if variation == nil {
+			return fmt.Errorf("variation %d of test %s is nil", i, test.Type)
 		}
+	}
 
-		if test.Probability != nil && len(test.Probability) != len(test.Variations) {
-			panic("different number of tests and probabilities")
+	if test.Probability != nil {
+		_, err := pickVariation(test, 0)
+		if err != nil {
+			return err
 		}
+	}
 
-		t.testArr = append(t.testArr, test)
+	if test.Allocation < 0 || test.Allocation > 1 {
+		return fmt.Errorf("allocation must be between 0 and 1 %s", test.Type)
 	}
+
+	err = test.validateLifecycle()
+	if err != nil {
+		return err
+	}
+
+	return test.validateMode()
 }
 
 // AB will return a gobbl middleware generated for the given test type.
-// when the bot calls this middleware, it will decide which handler to call based on the user
+// when the bot calls this middleware, it will decide which handler to call based on the user.
+// If the user's variation cannot be determined, they receive the test's control
 func (t *Tester) AB(testType string) gbl.MiddlewareFunction {
 	return func(c *gbl.Context) {
-		var abSuite TestSuite
+		abSuite := t.contextSuite(c)
 
-		if c.HasFlag("__abSuite") {
-			abSuite = c.GetFlag("__abSuite").(TestSuite)
-			c.Tracef("Loaded AB Suite %+v", abSuite)
-		} else {
+		test := t.getTest(testType)
 
-			id := c.User.ID
-
-			if t.idgen != nil {
-				id = t.idgen(c)
-			}
+		if test.Type == "" {
+			c.Errorf("Invalid AB test type %s", testType)
 
-			suite, err := genSuite(t, id)
-			if err != nil {
-				c.Errorf("Error generating AB suite for user %v", err)
+			if fallback := t.fallbackHandler(); fallback != nil {
+				fallback(c)
+			} else {
+				c.Next()
 			}
 
-			abSuite = suite
-
-			c.Flag("__abSuite", abSuite)
-			c.Tracef("Generated AB Suite %+v", abSuite)
+			return
 		}
 
-		var test Test
+		if override, exists := t.ActiveOverrides(c)[testType]; exists && validVariation(test, override) {
+			c.Tracef("Using overridden variation %d for AB test %s", override, testType)
+			test.Variations[override](c)
+			return
+		}
 
-		for _, searchTest := range t.testArr {
-			if searchTest.Type == testType {
-				test = searchTest
-				break
-			}
+		if test.Status == Concluded {
+			c.Tracef("AB test %s is concluded, using winner %d", testType, test.Winner)
+			winner(test)(c)
+			return
 		}
 
-		if test.Type == "" {
-			panic("invalid test type " + testType)
+		if !test.Running(time.Now()) {
+			c.Tracef("AB test %s is not running, using control", testType)
+			control(test)(c)
+			return
 		}
 
 		variationIdx, exists := abSuite[testType]
 		if !exists {
-			panic(fmt.Sprintf("test type %s was not registered", testType))
+			c.Errorf("AB suite is missing test type %s, using control", testType)
+			control(test)(c)
+			return
+		}
+
+		if variationIdx == NotEnrolled || !eligible(c, test) {
+			c.Tracef("User is not enrolled in AB test %s", testType)
+			control(test)(c)
+			return
 		}
-		// TODO cache generated suite
+
+		t.record(c, testType, variationIdx)
+		t.emit(c, Event{Type: EventExposure, TestType: testType, Variation: variationIdx})
 
 		test.Variations[variationIdx](c)
 	}
 }
 
-// GetSuite returns a suite of ab test selections for a given id
-func (t *Tester) GetSuite(id string) TestSuite {
-	suite, err := genSuite(t, id)
-	if err != nil {
-		panic(err)
+// getTest returns the registered test with the given type, or a blank test if
+// no test with that type was registered
+func (t *Tester) getTest(testType string) Test {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for _, test := range t.testArr {
+		if test.Type == testType {
+			return test
+		}
 	}
 
-	return suite
+	return Test{}
+}
+
+// tests returns a copy of the registered tests
+func (t *Tester) tests() []Test {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return append([]Test{}, t.testArr...)
+}
+
+// assignmentStore returns the assignment store, it is nil if no store is used
+func (t *Tester) assignmentStore() AssignmentStore {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.store
+}
+
+// eventSink returns the event sink, it is nil if no sink is used
+func (t *Tester) eventSink() EventSink {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.sink
+}
+
+// fallbackHandler returns the handler used for unregistered test types
+func (t *Tester) fallbackHandler() gbl.MiddlewareFunction {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.fallback
 }
 
-// genSuite will generate the entire suite of test variations for a given id
-func genSuite(t *Tester, id string) (TestSuite, error) {
-	rand, err := getRand(id)
+// GetSuite returns a suite of ab test selections for a given id
+func (t *Tester) GetSuite(id string) (TestSuite, error) {
+	suite, _, err := t.loadSuite(id)
 	if err != nil {
 		return nil, err
 	}
 
-	suite := TestSuite{}
+	for testType, variation := range t.userOverrides(id) {
+		if validVariation(t.getTest(testType), variation) {
+			suite[testType] = variation
+		}
+	}
 
-	for _, test := range t.testArr {
-		if test.Probability == nil {
-			suite[test.Type] = rand.Intn(len(test.Variations))
-		} else {
-			if len(test.Probability) != len(test.Variations) {
-				panic("incorrect number of probabilities provided " + test.Type)
-			}
+	return suite, nil
+}
 
-			var totalWeight float64
+// contextSuite will return the suite for the user on the context,
+// the suite is only loaded once per request
+func (t *Tester) contextSuite(c *gbl.Context) TestSuite {
+	if c.HasFlag("__abSuite") {
+		abSuite := c.GetFlag("__abSuite").(TestSuite)
+		c.Tracef("Loaded AB Suite %+v", abSuite)
+		return abSuite
+	}
 
-			for _, prob := range test.Probability {
-				if prob < 0 {
-					panic("probability cannot be a negative number")
-				}
+	abSuite, recorded, err := t.loadSuite(t.contextID(c))
+	if err != nil {
+		c.Errorf("Error generating AB suite for user %v", err)
+	}
 
-				totalWeight += prob
-			}
+	c.Flag("__abSuite", abSuite)
+	c.Flag("__abRecorded", recorded)
+	c.Tracef("Generated AB Suite %+v", abSuite)
 
-			if totalWeight <= 0 {
-				panic("probabilities must add up to a positive number")
-			}
+	return abSuite
+}
 
-			randomNumber := rand.Float64() * totalWeight
+// contextID will return the id used to select the suite of the user on the context
+func (t *Tester) contextID(c *gbl.Context) string {
+	if c.HasFlag("__abId") {
+		return c.GetStringFlag("__abId")
+	}
 
-			for i := 0; i < len(test.Variations); i++ {
-				randomNumber -= test.Probability[i]
+	id := c.User.ID
 
-				if randomNumber <= 0 {
-					suite[test.Type] = i
-					break
-				}
-			}
-		}
+	if t.idgen != nil {
+		id = t.idgen(c)
 	}
 
-	return suite, nil
+	c.Flag("__abId", id)
+
+	return id
 }
 
-// getRand will return a rand object that is seeded
-func getRand(id string) (*rand.Rand, error) {
-	i, err := hashToInt(id)
+// record will save the variation to the assignment store the first time
+// a user is exposed to a test
+func (t *Tester) record(c *gbl.Context, testType string, variation int) {
+	store := t.assignmentStore()
+	if store == nil {
+		return
+	}
+
+	// A missing or nil recorded suite means the store could not be read (or the suite
+	// was set on the context by the caller), so nothing is saved to avoid overwriting
+	// assignments that may already exist
+	recorded, ok := c.GetFlag("__abRecorded").(TestSuite)
+	if !ok || recorded == nil {
+		return
+	}
+
+	if _, exists := recorded[testType]; exists {
+		return
+	}
+
+	err := store.Save(t.contextID(c), TestSuite{testType: variation})
 	if err != nil {
-		return nil, err
+		c.Errorf("Error saving AB assignment %v", err)
+		return
 	}
 
-	return rand.New(rand.NewSource(i)), nil
+	recorded[testType] = variation
 }
 
-func hashToInt(id string) (int64, error) {
-	hash := sha256.New()
-	_, err := hash.Write([]byte(id))
+// loadSuite will return the full suite for an id. Assignments already present in
+// the assignment store are reused, only tests the id has never seen are generated.
+// The stored assignments are returned as the second value
+func (t *Tester) loadSuite(id string) (TestSuite, TestSuite, error) {
+	tests := t.tests()
+	store := t.assignmentStore()
+	recorded := TestSuite{}
+
+	if store == nil {
+		generated, genErr := genSuite(t, tests, id, nil)
+		return generated, recorded, genErr
+	}
+
+	stored, err := store.Get(id)
 	if err != nil {
-		return 0, err
+		generated, _ := genSuite(t, tests, id, nil)
+		return generated, nil, err
 	}
 
-	hashBytes := hash.Sum(nil)
+	generated, genErr := genSuite(t, tests, id, stored)
+
+	for _, test := range tests {
+		variation, exists := stored[test.Type]
+		if !exists || !validVariation(test, variation) {
+			continue
+		}
+
+		generated[test.Type] = variation
+		recorded[test.Type] = variation
+	}
+
+	enforceLayers(tests, generated, recorded)
+
+	return generated, recorded, genErr
+}
+
+// genSuite will generate the entire suite of test variations for a given id.
+// Tests that cannot be generated are left out of the suite and the first error is returned.
+// Adaptive tests that are already in stored keep their stored variation, so the stats
+// are only read for users that have not been assigned yet
+func genSuite(t *Tester, tests []Test, id string, stored TestSuite) (TestSuite, error) {
+	suite := TestSuite{}
+
+	var firstErr error
+
+	layers := layerSelections(tests, id)
+
+	for _, test := range tests {
+		if test.Layer != "" && layers[test.Layer] != test.Type {
+			suite[test.Type] = NotEnrolled
+			continue
+		}
+
+		if test.Layer == "" && !allocated(test, id) {
+			suite[test.Type] = NotEnrolled
+			continue
+		}
+
+		if variation, exists := stored[test.Type]; exists && test.Mode != Fixed && validVariation(test, variation) {
+			suite[test.Type] = variation
+			continue
+		}
 
-	i := int64(binary.LittleEndian.Uint64(hashBytes[:8]))
+		var variation int
+		var err error
+
+		if test.Mode == Fixed {
+			variation, err = pickVariation(test, Bucket(id, test.Type, test.Salt))
+		} else {
+			variation, err = t.pickAdaptive(test, id)
+		}
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		suite[test.Type] = variation
+	}
 
-	return i, nil
+	return suite, firstErr
 }
@@ -1,11 +1,21 @@
 package ab
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/calebhiebert/gobbl"
+	"github.com/calebhiebert/gobbl/session"
 )
 
 func TestHashToInt(t *testing.T) {
@@ -38,7 +48,7 @@ func TestHashToInt(t *testing.T) {
 			Test{Type: "prob", Variations: TestList{h1, h2, h3}, Probability: []float64{0.5, 0.5, 0.01}},
 		)
 
-		suite, err := genSuite(ab, id)
+		suite, err := genSuite(ab, ab.tests(), id, nil)
 		if err != nil {
 			t.Error(err)
 		}
@@ -46,3 +56,739 @@ func TestHashToInt(t *testing.T) {
 		fmt.Println(suite)
 	}
 }
+
+func newTestContext(id string) *gbl.Context {
+	c := gbl.InputContext{}.Transform(gbl.New())
+	c.User.ID = id
+	c.Next = func() {}
+
+	return c
+}
+
+func TestAssignmentStore(t *testing.T) {
+	handler := func(variation int) gbl.MiddlewareFunction {
+		return func(c *gbl.Context) {
+			c.Flag("variation", variation)
+		}
+	}
+
+	store := MemoryStore()
+
+	ab := New()
+	ab.UseStore(store)
+	ab.Register(Test{Type: "greeting", Variations: TestList{handler(0), handler(1)}, Probability: []float64{1, 0}})
+
+	c := newTestContext("123")
+	ab.AB("greeting")(c)
+
+	if c.GetIntFlag("variation") != 0 {
+		t.Errorf("Expected variation 0, got %d", c.GetIntFlag("variation"))
+	}
+
+	// Flip the weights, the user has already been exposed so they should stay in variation 0
+	ab = New()
+	ab.UseStore(store)
+	ab.Register(
+		Test{Type: "new-test", Variations: TestList{handler(0), handler(1)}},
+		Test{Type: "greeting", Variations: TestList{handler(0), handler(1)}, Probability: []float64{0, 1}},
+	)
+
+	c = newTestContext("123")
+	ab.AB("greeting")(c)
+
+	if c.GetIntFlag("variation") != 0 {
+		t.Errorf("Stored assignment was not reused, got variation %d", c.GetIntFlag("variation"))
+	}
+
+	c = newTestContext("456")
+	ab.AB("greeting")(c)
+
+	if c.GetIntFlag("variation") != 1 {
+		t.Errorf("Expected new user to get variation 1, got %d", c.GetIntFlag("variation"))
+	}
+
+	suite, _ := store.Get("123")
+	if _, exists := suite["new-test"]; exists {
+		t.Error("Tests should only be recorded on exposure")
+	}
+
+	// Suites set on the context by the caller are used as is and never saved
+	c = newTestContext("789")
+	c.Flag("__abSuite", TestSuite{"greeting": 1})
+	ab.AB("greeting")(c)
+
+	if suite, _ := store.Get("789"); c.GetIntFlag("variation") != 1 || len(suite) != 0 {
+		t.Errorf("Expected the context suite to be used without saving it, got variation %d and %v", c.GetIntFlag("variation"), suite)
+	}
+}
+
+func TestSessionAssignmentStore(t *testing.T) {
+	store := SessionStore(sess.MemoryStore(), "ab:")
+	done := &sync.WaitGroup{}
+
+	for i := 0; i < 20; i++ {
+		done.Add(2)
+
+		go func(i int) {
+			defer done.Done()
+			store.Save("123", TestSuite{"test-" + strconv.Itoa(i): i % 2})
+		}(i)
+
+		go func() {
+			defer done.Done()
+			store.Get("123")
+		}()
+	}
+
+	done.Wait()
+
+	suite, err := store.Get("123")
+	if err != nil || len(suite) != 20 {
+		t.Errorf("Expected every assignment to be merged, got %v %v", suite, err)
+	}
+
+	// Users without stored assignments have no session yet, the store must
+	// treat that as empty so their first exposure is saved
+	handler := func(variation int) gbl.MiddlewareFunction {
+		return func(c *gbl.Context) {
+			c.Flag("variation", variation)
+		}
+	}
+
+	sessionStore := sess.MemoryStore()
+
+	ab := New()
+	ab.UseStore(SessionStore(sessionStore, "ab:"))
+	ab.Register(Test{Type: "greeting", Variations: TestList{handler(0), handler(1)}, Probability: []float64{1, 0}})
+
+	ab.AB("greeting")(newTestContext("456"))
+
+	ab = New()
+	ab.UseStore(SessionStore(sessionStore, "ab:"))
+	ab.Register(Test{Type: "greeting", Variations: TestList{handler(0), handler(1)}, Probability: []float64{0, 1}})
+
+	c := newTestContext("456")
+	ab.AB("greeting")(c)
+
+	if c.GetIntFlag("variation") != 0 {
+		t.Errorf("Expected the first exposure to be saved, got variation %d", c.GetIntFlag("variation"))
+	}
+}
+
+func TestConcurrentRegister(t *testing.T) {
+	h := func(c *gbl.Context) {}
+
+	ab := New()
+	ab.Register(Test{Type: "greeting", Variations: TestList{h, h}})
+
+	done := &sync.WaitGroup{}
+
+	for i := 0; i < 20; i++ {
+		done.Add(2)
+
+		go func(i int) {
+			defer done.Done()
+			ab.Register(Test{Type: "adaptive-" + strconv.Itoa(i), Variations: TestList{h, h}, Mode: EpsilonGreedy, Goal: "signup"})
+			ab.UseSink(Aggregator())
+		}(i)
+
+		go func(i int) {
+			defer done.Done()
+			ab.AB("greeting")(newTestContext(strconv.Itoa(i)))
+		}(i)
+	}
+
+	done.Wait()
+}
+
+func TestAggregator(t *testing.T) {
+	agg := Aggregator()
+
+	for i := 0; i < 1000; i++ {
+		id := strconv.Itoa(i)
+		variation := i % 2
+
+		agg.Record(Event{Type: EventExposure, TestType: "greeting", Variation: variation, ID: id})
+		agg.Record(Event{Type: EventExposure, TestType: "greeting", Variation: variation, ID: id})
+
+		// Variation 0 converts 10% of the time, variation 1 converts 20% of the time
+		if (variation == 0 && i%20 == 0) || (variation == 1 && i%5 == 1) {
+			agg.Record(Event{Type: EventConversion, TestType: "greeting", Variation: variation, ID: id, Goal: "signup"})
+		}
+	}
+
+	stats := agg.Stats("greeting", "signup")
+	if len(stats) != 2 {
+		t.Fatalf("Expected stats for 2 variations, got %d", len(stats))
+	}
+
+	if stats[0].Exposures != 500 || stats[0].Conversions != 50 {
+		t.Errorf("Incorrect control counts %+v", stats[0])
+	}
+
+	if stats[1].ConversionRate != 0.2 {
+		t.Errorf("Incorrect conversion rate, expected 0.2, got %f", stats[1].ConversionRate)
+	}
+
+	if !stats[1].Significant || stats[1].ZScore <= 0 {
+		t.Errorf("Expected variation 1 to be significantly better %+v", stats[1])
+	}
+}
+
+func TestConvert(t *testing.T) {
+	agg := Aggregator()
+
+	ab := New()
+	ab.UseSink(agg)
+	ab.Register(Test{Type: "greeting", Variations: TestList{func(c *gbl.Context) {}}})
+
+	for i := 0; i < 10; i++ {
+		c := newTestContext(strconv.Itoa(i))
+
+		// Only half of the users are shown the test, everyone converts
+		if i%2 == 0 {
+			ab.AB("greeting")(c)
+		}
+
+		ab.Convert(c, "greeting", "signup")
+	}
+
+	stats := agg.Stats("greeting", "signup")
+	if len(stats) != 1 || stats[0].Exposures != 5 || stats[0].Conversions != 5 || stats[0].ConversionRate != 1 {
+		t.Errorf("Expected only conversions of exposed users to count, got %+v", stats)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	// These values are computed independently, other services rely on them staying the same
+	if Bucket("123", "greeting", "") != 6097 {
+		t.Errorf("Bucket algorithm changed, expected 6097, got %d", Bucket("123", "greeting", ""))
+	}
+
+	if Bucket("123", "greeting", "v2") != 733 {
+		t.Errorf("Bucket algorithm changed, expected 733, got %d", Bucket("123", "greeting", "v2"))
+	}
+
+	h := func(c *gbl.Context) {}
+
+	forward := New()
+	forward.Register(
+		Test{Type: "menu-order", Variations: TestList{h, h, h}},
+		Test{Type: "greeting", Variations: TestList{h, h}, Probability: []float64{0.2, 0.8}},
+	)
+
+	backward := New()
+	backward.Register(
+		Test{Type: "greeting", Variations: TestList{h, h}, Probability: []float64{0.2, 0.8}},
+		Test{Type: "menu-order", Variations: TestList{h, h, h}},
+	)
+
+	counts := []int{0, 0}
+
+	for i := 0; i < 1000; i++ {
+		id := strconv.Itoa(i)
+
+		f, err := forward.GetSuite(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := backward.GetSuite(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f["greeting"] != b["greeting"] || f["menu-order"] != b["menu-order"] {
+			t.Errorf("Registration order changed the suite for %s: %v %v", id, f, b)
+		}
+
+		counts[f["greeting"]]++
+	}
+
+	if counts[0] < 150 || counts[0] > 250 {
+		t.Errorf("Expected roughly 200 users in variation 0, got %d", counts[0])
+	}
+}
+
+func TestTargeting(t *testing.T) {
+	handler := func(name string) gbl.MiddlewareFunction {
+		return func(c *gbl.Context) {
+			c.Flag("handler", name)
+		}
+	}
+
+	ab := New()
+	ab.Register(
+		Test{Type: "greeting", Variations: TestList{handler("v0"), handler("v1")}, Rules: []TargetingRule{Lang("fr-CA")}, Control: handler("control")},
+		Test{Type: "menu-order", Variations: TestList{handler("v0"), handler("v1")}, Allocation: 0.1, Control: handler("control")},
+	)
+
+	c := newTestContext("123")
+	c.Flag("lang", "en-US")
+	ab.AB("greeting")(c)
+
+	if c.GetStringFlag("handler") != "control" {
+		t.Errorf("Ineligible user should receive the control, got %s", c.GetStringFlag("handler"))
+	}
+
+	c = newTestContext("123")
+	c.Flag("lang", "fr-CA")
+	ab.AB("greeting")(c)
+
+	if c.GetStringFlag("handler") == "control" {
+		t.Error("Eligible user should receive a variation")
+	}
+
+	enrolled := 0
+
+	for i := 0; i < 1000; i++ {
+		c := newTestContext(strconv.Itoa(i))
+		ab.AB("menu-order")(c)
+
+		if c.GetStringFlag("handler") != "control" {
+			enrolled++
+		}
+	}
+
+	if enrolled < 60 || enrolled > 140 {
+		t.Errorf("Expected roughly 100 enrolled users, got %d", enrolled)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	handler := func(variation int) gbl.MiddlewareFunction {
+		return func(c *gbl.Context) {
+			c.Flag("variation", variation)
+		}
+	}
+
+	ab := New()
+	ab.Register(Test{Type: "greeting", Variations: TestList{handler(0), handler(1), handler(2)}, Probability: []float64{1, 0, 0}})
+
+	ab.Override("123", "greeting", 1)
+
+	suite, err := ab.GetSuite("123")
+	if err != nil || suite["greeting"] != 1 {
+		t.Errorf("GetSuite should respect overrides, got %v %v", suite, err)
+	}
+
+	c := newTestContext("123")
+	ab.AB("greeting")(c)
+
+	if c.GetIntFlag("variation") != 1 {
+		t.Errorf("Expected overridden variation 1, got %d", c.GetIntFlag("variation"))
+	}
+
+	// Forcing with the command should take precedence over the static override
+	c = newTestContext("123")
+	c.Request.Text = "/ab greeting 2"
+	ab.CommandMiddleware("/ab")(c)
+	ab.AB("greeting")(c)
+
+	if c.GetIntFlag("variation") != 2 {
+		t.Errorf("Expected forced variation 2, got %d", c.GetIntFlag("variation"))
+	}
+
+	if len(ab.Overrides()) != 1 || ab.ActiveOverrides(c)["greeting"] != 2 {
+		t.Errorf("Incorrect overrides listed %v %v", ab.Overrides(), ab.ActiveOverrides(c))
+	}
+
+	ab.ClearOverride("123")
+	ClearForced(c)
+	c.ClearFlag("__abSuite")
+	ab.AB("greeting")(c)
+
+	if c.GetIntFlag("variation") != 0 {
+		t.Errorf("Expected variation 0 after clearing overrides, got %d", c.GetIntFlag("variation"))
+	}
+}
+
+func TestRegisterE(t *testing.T) {
+	h := func(c *gbl.Context) {}
+
+	invalid := []Test{
+		{Variations: TestList{h}},
+		{Type: "no-variations"},
+		{Type: "nil-variation", Variations: TestList{h, nil}},
+		{Type: "probabilities", Variations: TestList{h, h}, Probability: []float64{1}},
+		{Type: "negative", Variations: TestList{h, h}, Probability: []float64{1, -1}},
+		{Type: "allocation", Variations: TestList{h}, Allocation: 2},
+	}
+
+	ab := New()
+
+	for _, test := range invalid {
+		if err := ab.RegisterE(test); err == nil {
+			t.Errorf("Expected an error registering %+v", test)
+		}
+	}
+
+	if err := ab.RegisterE(Test{Type: "greeting", Variations: TestList{h}}); err != nil {
+		t.Error(err)
+	}
+
+	if err := ab.RegisterE(Test{Type: "greeting", Variations: TestList{h}}); err == nil {
+		t.Error("Expected an error registering a duplicate test type")
+	}
+
+	c := newTestContext("123")
+	c.Next = func() {
+		c.Flag("next", true)
+	}
+
+	ab.AB("unknown")(c)
+
+	if !c.HasFlag("next") {
+		t.Error("Unknown test types should continue down the chain")
+	}
+
+	ab.UseFallback(func(c *gbl.Context) {
+		c.Flag("fallback", true)
+	})
+
+	ab.AB("unknown")(c)
+
+	if !c.HasFlag("fallback") {
+		t.Error("Unknown test types should use the fallback")
+	}
+}
+
+func TestBandit(t *testing.T) {
+	h := func(c *gbl.Context) {}
+
+	for _, mode := range []AllocationMode{EpsilonGreedy, ThompsonSampling} {
+		agg := Aggregator()
+
+		ab := New()
+		ab.UseSink(agg)
+		ab.Register(Test{Type: "greeting", Variations: TestList{h, h}, Mode: mode, Goal: "signup"})
+
+		// Expose a user before any results exist, they should keep their variation
+		c := newTestContext("sticky")
+		ab.AB("greeting")(c)
+		stickyVariation := c.GetFlag("__abSuite").(TestSuite)["greeting"]
+
+		for i := 0; i < 200; i++ {
+			id := "seed" + strconv.Itoa(i)
+			variation := i % 2
+
+			agg.Record(Event{Type: EventExposure, TestType: "greeting", Variation: variation, ID: id})
+
+			if variation == 1 || i%10 == 0 {
+				agg.Record(Event{Type: EventConversion, TestType: "greeting", Variation: variation, ID: id, Goal: "signup"})
+			}
+		}
+
+		best := 0
+
+		for i := 0; i < 200; i++ {
+			suite, err := ab.GetSuite(strconv.Itoa(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if suite["greeting"] == 1 {
+				best++
+			}
+		}
+
+		if best < 160 {
+			t.Errorf("%s: expected most users in the best variation, got %d of 200", mode, best)
+		}
+
+		c = newTestContext("sticky")
+		ab.AB("greeting")(c)
+
+		if c.GetFlag("__abSuite").(TestSuite)["greeting"] != stickyVariation {
+			t.Errorf("%s: exposed user should keep their variation", mode)
+		}
+
+		// Users that are already assigned should not read the stats
+		counting := &countingSink{aggregator: agg}
+		ab.UseSink(counting)
+
+		ab.AB("greeting")(newTestContext("sticky"))
+
+		if counting.stats != 0 {
+			t.Errorf("%s: expected no stats to be read for an assigned user, got %d reads", mode, counting.stats)
+		}
+	}
+}
+
+type countingSink struct {
+	*aggregator
+	stats int
+}
+
+func (s *countingSink) Stats(testType, goal string) []VariationStats {
+	s.stats++
+	return s.aggregator.Stats(testType, goal)
+}
+
+func TestLifecycle(t *testing.T) {
+	handler := func(name string) gbl.MiddlewareFunction {
+		return func(c *gbl.Context) {
+			c.Flag("handler", name)
+		}
+	}
+
+	ab := New()
+	ab.Register(Test{Type: "greeting", Variations: TestList{handler("v0"), handler("v1")}, Probability: []float64{1, 0}, Control: handler("control"), Status: Draft})
+
+	run := func() string {
+		c := newTestContext("123")
+		ab.AB("greeting")(c)
+		return c.GetStringFlag("handler")
+	}
+
+	if run() != "control" {
+		t.Error("Draft tests should use the control")
+	}
+
+	if err := ab.SetStatus("greeting", Running); err != nil || run() != "v0" {
+		t.Errorf("Running tests should enroll users %v", err)
+	}
+
+	if err := ab.SetWindow("greeting", time.Now().Add(time.Hour), time.Time{}); err != nil || run() != "control" {
+		t.Errorf("Tests should not run before their start time %v", err)
+	}
+
+	if err := ab.DeclareWinner("greeting", 5); err == nil {
+		t.Error("Expected an error declaring an invalid winner")
+	}
+
+	if err := ab.DeclareWinner("greeting", 1); err != nil || run() != "v1" {
+		t.Errorf("Concluded tests should use the winner %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "ab.yaml")
+
+	err = ioutil.WriteFile(config, []byte(`
+tests:
+  - type: greeting
+    status: running
+    start: 2019-01-01T00:00:00Z
+    end: 2019-02-01T00:00:00Z
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ab.LoadConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	test := ab.getTest("greeting")
+	if test.Status != Running || test.End.Month() != time.February {
+		t.Errorf("Config was not applied %+v", test)
+	}
+
+	if run() != "control" {
+		t.Error("Tests should not run after their end time")
+	}
+}
+
+func TestLayers(t *testing.T) {
+	h := func(c *gbl.Context) {}
+
+	ab := New()
+	ab.Register(
+		Test{Type: "greeting-short", Variations: TestList{h, h}, Layer: "greeting"},
+		Test{Type: "greeting-emoji", Variations: TestList{h, h}, Layer: "greeting"},
+		Test{Type: "menu-order", Variations: TestList{h, h}},
+	)
+
+	if err := ab.RegisterE(Test{Type: "greeting-long", Variations: TestList{h}, Layer: "greeting", Allocation: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ab.RegisterE(Test{Type: "greeting-gif", Variations: TestList{h}, Layer: "greeting", Allocation: 0.6}); err == nil {
+		t.Error("Expected an error when layer allocations add up to more than 1")
+	}
+
+	counts := map[string]int{}
+
+	for i := 0; i < 1000; i++ {
+		suite, err := ab.GetSuite(strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		enrolled := 0
+
+		for _, testType := range []string{"greeting-short", "greeting-emoji", "greeting-long"} {
+			if suite[testType] != NotEnrolled {
+				enrolled++
+				counts[testType]++
+			}
+		}
+
+		if enrolled != 1 {
+			t.Errorf("User %d should be enrolled in exactly one greeting test %v", i, suite)
+		}
+
+		if suite["menu-order"] == NotEnrolled {
+			t.Errorf("User %d should be enrolled in tests outside of the layer", i)
+		}
+	}
+
+	if counts["greeting-long"] < 400 || counts["greeting-short"] < 150 || counts["greeting-emoji"] < 150 {
+		t.Errorf("Layer was not split correctly %v", counts)
+	}
+
+	// 0.7 + 0.2 + 0.1 adds up to slightly less than 1 in floating point
+	layered := []Test{
+		{Type: "a", Layer: "rounding", Allocation: 0.7},
+		{Type: "b", Layer: "rounding", Allocation: 0.2},
+		{Type: "c", Layer: "rounding", Allocation: 0.1},
+	}
+
+	for i := 0; i < 100000; i++ {
+		id := strconv.Itoa(i)
+
+		if Bucket(id, "layer:rounding", "") != BucketCount-1 {
+			continue
+		}
+
+		if selection := layerSelections(layered, id)["rounding"]; selection != "c" {
+			t.Errorf("Expected the last bucket to belong to the last test, got %q", selection)
+		}
+
+		break
+	}
+}
+
+func TestMultivariate(t *testing.T) {
+	level := func(flag, value string) gbl.MiddlewareFunction {
+		return func(c *gbl.Context) {
+			c.Flag(flag, value)
+			c.Next()
+		}
+	}
+
+	ab := New()
+	ab.Register(Test{
+		Type: "greeting",
+		Factors: []Factor{
+			{Name: "text", Levels: TestList{level("text", "short"), level("text", "long")}},
+			{Name: "emoji", Levels: TestList{level("emoji", "none"), level("emoji", "wave"), level("emoji", "smile")}},
+		},
+	})
+
+	test := ab.getTest("greeting")
+	if len(test.Variations) != 6 {
+		t.Fatalf("Expected 6 variations, got %d", len(test.Variations))
+	}
+
+	c := newTestContext("123")
+	c.Next = func() {
+		c.Flag("next", true)
+	}
+
+	test.Variations[4](c)
+
+	levels := test.FactorLevels(4)
+	if levels["text"] != 1 || levels["emoji"] != 1 {
+		t.Errorf("Incorrect factor levels %v", levels)
+	}
+
+	if c.GetStringFlag("text") != "long" || c.GetStringFlag("emoji") != "wave" || !c.HasFlag("next") {
+		t.Errorf("Factor handlers were not chained %v", c.Flags)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	h := func(c *gbl.Context) {}
+
+	ab := New()
+	ab.UseSink(Aggregator())
+	ab.Register(Test{Type: "greeting", Variations: TestList{h, h}, Probability: []float64{1, 0}, Goal: "signup"})
+
+	server := httptest.NewServer(ab.AdminHandler())
+	defer server.Close()
+
+	// Keep the tester busy while it is being edited
+	wg := sync.WaitGroup{}
+	done := make(chan bool)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					ab.AB("greeting")(newTestContext(strconv.Itoa(i)))
+				}
+			}
+		}(i)
+	}
+
+	request := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return res
+	}
+
+	res := request(http.MethodPut, "/tests/greeting/probability", `{"probability": [0, 1]}`)
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected probability update to succeed, got %d", res.StatusCode)
+	}
+
+	res = request(http.MethodPut, "/tests/greeting/probability", `{"probability": [1]}`)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected invalid probability update to fail, got %d", res.StatusCode)
+	}
+
+	res = request(http.MethodPost, "/overrides", `{"id": "override", "test": "greeting", "variation": 0}`)
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected override to succeed, got %d", res.StatusCode)
+	}
+
+	var suite TestSuite
+
+	res = request(http.MethodGet, "/suite?id=override", "")
+	json.NewDecoder(res.Body).Decode(&suite)
+
+	if suite["greeting"] != 0 {
+		t.Errorf("Expected overridden suite, got %v", suite)
+	}
+
+	res = request(http.MethodGet, "/suite?id=someone", "")
+	json.NewDecoder(res.Body).Decode(&suite)
+
+	if suite["greeting"] != 1 {
+		t.Errorf("Expected updated probability to be used, got %v", suite)
+	}
+
+	close(done)
+	wg.Wait()
+
+	var tests []testInfo
+
+	res = request(http.MethodGet, "/tests", "")
+	json.NewDecoder(res.Body).Decode(&tests)
+
+	if len(tests) != 1 || len(tests[0].Stats) == 0 {
+		t.Errorf("Expected test listing with stats, got %+v", tests)
+	}
+}
@@ -0,0 +1,237 @@
+package ab
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// testInfo is the admin representation of a registered test
+type testInfo struct {
+	Type        string           `json:"type"`
+	Variations  int              `json:"variations"`
+	Probability []float64        `json:"probability"`
+	Allocation  float64          `json:"allocation"`
+	Layer       string           `json:"layer,omitempty"`
+	Mode        AllocationMode   `json:"mode,omitempty"`
+	Goal        string           `json:"goal,omitempty"`
+	Status      Status           `json:"status"`
+	Running     bool             `json:"running"`
+	Start       time.Time        `json:"start"`
+	End         time.Time        `json:"end"`
+	Winner      int              `json:"winner"`
+	Stats       []VariationStats `json:"stats,omitempty"`
+}
+
+// overrideRequest is the body used to add or remove an override
+type overrideRequest struct {
+	ID        string `json:"id"`
+	Test      string `json:"test"`
+	Variation int    `json:"variation"`
+}
+
+// statusRequest is the body used to change the status of a test
+type statusRequest struct {
+	Status Status `json:"status"`
+	Winner int    `json:"winner"`
+}
+
+// probabilityRequest is the body used to change the probabilities of a test
+type probabilityRequest struct {
+	Probability []float64 `json:"probability"`
+}
+
+type adminHandler struct {
+	tester *Tester
+}
+
+/*
+AdminHandler returns an http handler that can be used to inspect and edit tests at runtime.
+The handler does no authentication, so it should be mounted behind some.
+Every endpoint responds with JSON
+
+	GET    /tests                      lists every test, with stats if the sink is a StatsSource (?goal= overrides the test's goal)
+	PUT    /tests/{type}/probability   sets the probabilities of a test {"probability": [0.5, 0.5]}
+	PUT    /tests/{type}/status        sets the status of a test {"status": "concluded", "winner": 1}
+	GET    /suite?id={id}              returns the suite for a user id
+	GET    /overrides                  lists every static override
+	POST   /overrides                  adds an override {"id": "123", "test": "greeting", "variation": 1}
+	DELETE /overrides                  removes an override {"id": "123", "test": "greeting"}
+*/
+func (t *Tester) AdminHandler() http.Handler {
+	return &adminHandler{tester: t}
+}
+
+func (a *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(path) == 1 && path[0] == "tests" && r.Method == http.MethodGet:
+		a.listTests(w, r)
+	case len(path) == 3 && path[0] == "tests" && path[2] == "probability" && r.Method == http.MethodPut:
+		a.setProbability(w, r, path[1])
+	case len(path) == 3 && path[0] == "tests" && path[2] == "status" && r.Method == http.MethodPut:
+		a.setStatus(w, r, path[1])
+	case len(path) == 1 && path[0] == "suite" && r.Method == http.MethodGet:
+		a.getSuite(w, r)
+	case len(path) == 1 && path[0] == "overrides" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, a.tester.Overrides())
+	case len(path) == 1 && path[0] == "overrides" && r.Method == http.MethodPost:
+		a.addOverride(w, r)
+	case len(path) == 1 && path[0] == "overrides" && r.Method == http.MethodDelete:
+		a.removeOverride(w, r)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no route for %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (a *adminHandler) listTests(w http.ResponseWriter, r *http.Request) {
+	source, hasStats := a.tester.eventSink().(StatsSource)
+
+	tests := []testInfo{}
+
+	for _, test := range a.tester.tests() {
+		info := testInfo{
+			Type:        test.Type,
+			Variations:  len(test.Variations),
+			Probability: test.Probability,
+			Allocation:  test.Allocation,
+			Layer:       test.Layer,
+			Mode:        test.Mode,
+			Goal:        test.Goal,
+			Status:      test.Status,
+			Running:     test.Status != Concluded && test.Running(time.Now()),
+			Start:       test.Start,
+			End:         test.End,
+			Winner:      test.Winner,
+		}
+
+		if info.Status == "" {
+			info.Status = Running
+		}
+
+		if hasStats {
+			goal := r.URL.Query().Get("goal")
+			if goal == "" {
+				goal = test.Goal
+			}
+
+			info.Stats = source.Stats(test.Type, goal)
+		}
+
+		tests = append(tests, info)
+	}
+
+	writeJSON(w, http.StatusOK, tests)
+}
+
+func (a *adminHandler) setProbability(w http.ResponseWriter, r *http.Request, testType string) {
+	var body probabilityRequest
+
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	err := a.tester.SetProbability(testType, body.Probability)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.tester.getTest(testType).Probability)
+}
+
+func (a *adminHandler) setStatus(w http.ResponseWriter, r *http.Request, testType string) {
+	var body statusRequest
+
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	var err error
+
+	if body.Status == Concluded {
+		err = a.tester.DeclareWinner(testType, body.Winner)
+	} else {
+		err = a.tester.SetStatus(testType, body.Status)
+	}
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (a *adminHandler) getSuite(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing id"))
+		return
+	}
+
+	suite, err := a.tester.GetSuite(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, suite)
+}
+
+func (a *adminHandler) addOverride(w http.ResponseWriter, r *http.Request) {
+	var body overrideRequest
+
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	if body.ID == "" || !validVariation(a.tester.getTest(body.Test), body.Variation) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid override %+v", body))
+		return
+	}
+
+	a.tester.Override(body.ID, body.Test, body.Variation)
+
+	writeJSON(w, http.StatusOK, a.tester.Overrides())
+}
+
+func (a *adminHandler) removeOverride(w http.ResponseWriter, r *http.Request) {
+	var body overrideRequest
+
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	if body.Test == "" {
+		a.tester.ClearOverride(body.ID)
+	} else {
+		a.tester.ClearOverride(body.ID, body.Test)
+	}
+
+	writeJSON(w, http.StatusOK, a.tester.Overrides())
+}
+
+// readJSON decodes the request body, writing an error response if it is invalid
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
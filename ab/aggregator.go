@@ -0,0 +1,144 @@
+package ab
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// SignificanceLevel is the p-value below which a variation is considered
+// significantly different from the control
+var SignificanceLevel = 0.05
+
+// VariationStats holds the results of a single variation for a goal.
+// Exposures and conversions are counted once per user, conversions are only
+// counted for users that were exposed to the variation
+type VariationStats struct {
+	Variation      int     `json:"variation"`
+	Exposures      int     `json:"exposures"`
+	Conversions    int     `json:"conversions"`
+	ConversionRate float64 `json:"conversionRate"`
+
+	// ZScore and PValue are the result of a two-proportion z-test comparing
+	// this variation against the control (variation 0)
+	ZScore      float64 `json:"zScore"`
+	PValue      float64 `json:"pValue"`
+	Significant bool    `json:"significant"`
+}
+
+type variationKey struct {
+	testType  string
+	variation int
+}
+
+type goalKey struct {
+	testType  string
+	goal      string
+	variation int
+}
+
+type aggregator struct {
+	exposures   map[variationKey]map[string]bool
+	conversions map[goalKey]map[string]bool
+	mutex       *sync.Mutex
+}
+
+// Aggregator creates an event sink that keeps exposure and conversion counts in memory
+func Aggregator() *aggregator {
+	return &aggregator{
+		exposures:   make(map[variationKey]map[string]bool),
+		conversions: make(map[goalKey]map[string]bool),
+		mutex:       &sync.Mutex{},
+	}
+}
+
+// Record adds the event to the aggregated counts
+func (a *aggregator) Record(event Event) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch event.Type {
+	case EventExposure:
+		key := variationKey{testType: event.TestType, variation: event.Variation}
+
+		if _, exists := a.exposures[key]; !exists {
+			a.exposures[key] = make(map[string]bool)
+		}
+
+		a.exposures[key][event.ID] = true
+	case EventConversion:
+		key := goalKey{testType: event.TestType, goal: event.Goal, variation: event.Variation}
+
+		if _, exists := a.conversions[key]; !exists {
+			a.conversions[key] = make(map[string]bool)
+		}
+
+		a.conversions[key][event.ID] = true
+	}
+
+	return nil
+}
+
+// Stats returns the statistics for every variation of testType that has been
+// exposed, ordered by variation
+func (a *aggregator) Stats(testType, goal string) []VariationStats {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	stats := []VariationStats{}
+
+	for key, ids := range a.exposures {
+		if key.testType != testType {
+			continue
+		}
+
+		conversions := 0
+
+		// Convert can be called for users that were never shown the test,
+		// only conversions of exposed users count towards the rate
+		for id := range a.conversions[goalKey{testType: testType, goal: goal, variation: key.variation}] {
+			if ids[id] {
+				conversions++
+			}
+		}
+
+		stats = append(stats, VariationStats{
+			Variation:      key.variation,
+			Exposures:      len(ids),
+			Conversions:    conversions,
+			ConversionRate: float64(conversions) / float64(len(ids)),
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Variation < stats[j].Variation
+	})
+
+	if len(stats) == 0 || stats[0].Variation != 0 {
+		return stats
+	}
+
+	control := stats[0]
+
+	for i := 1; i < len(stats); i++ {
+		stats[i].ZScore, stats[i].PValue = twoProportionTest(control, stats[i])
+		stats[i].Significant = stats[i].PValue < SignificanceLevel
+	}
+
+	return stats
+}
+
+// twoProportionTest performs a two-tailed two-proportion z-test between the
+// conversion rates of a and b
+func twoProportionTest(a, b VariationStats) (float64, float64) {
+	pooled := float64(a.Conversions+b.Conversions) / float64(a.Exposures+b.Exposures)
+
+	standardError := math.Sqrt(pooled * (1 - pooled) * (1/float64(a.Exposures) + 1/float64(b.Exposures)))
+	if standardError == 0 {
+		return 0, 1
+	}
+
+	z := (b.ConversionRate - a.ConversionRate) / standardError
+
+	return z, math.Erfc(math.Abs(z) / math.Sqrt2)
+}
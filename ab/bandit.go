@@ -0,0 +1,159 @@
+package ab
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// AllocationMode is the strategy used to allocate new users to the variations of a test
+type AllocationMode string
+
+const (
+	// Fixed allocates users using the test's Probability weights
+	Fixed AllocationMode = ""
+
+	// EpsilonGreedy sends a fraction (Epsilon) of new users to a variation chosen with the
+	// Probability weights, and everyone else to the variation with the best conversion rate
+	EpsilonGreedy AllocationMode = "epsilon-greedy"
+
+	// ThompsonSampling draws a conversion rate for every variation from a beta distribution
+	// of its results, and sends new users to the variation with the highest draw
+	ThompsonSampling AllocationMode = "thompson-sampling"
+)
+
+// StatsSource is implemented by event sinks that can report the results of a test,
+// adaptive modes require the tester's sink to be a StatsSource
+type StatsSource interface {
+	Stats(testType, goal string) []VariationStats
+}
+
+// validateMode returns an error if the adaptive allocation settings are invalid
+func (test Test) validateMode() error {
+	switch test.Mode {
+	case Fixed:
+		return nil
+	case EpsilonGreedy, ThompsonSampling:
+	default:
+		return fmt.Errorf("unknown allocation mode %s for test %s", test.Mode, test.Type)
+	}
+
+	if test.Goal == "" {
+		return fmt.Errorf("allocation mode %s requires a goal for test %s", test.Mode, test.Type)
+	}
+
+	if test.Epsilon < 0 || test.Epsilon > 1 {
+		return fmt.Errorf("epsilon must be between 0 and 1 %s", test.Type)
+	}
+
+	return nil
+}
+
+/*
+pickAdaptive chooses a variation for a user that has not been assigned one yet.
+The random numbers are seeded from the id so the choice only changes when the
+results change, and the assignment store keeps it sticky once the user is exposed.
+If there are no results to work with, the Probability weights are used
+*/
+func (t *Tester) pickAdaptive(test Test, id string) (int, error) {
+	source, ok := t.eventSink().(StatsSource)
+	if !ok {
+		return pickVariation(test, Bucket(id, test.Type, test.Salt))
+	}
+
+	stats := make([]VariationStats, len(test.Variations))
+
+	for _, stat := range source.Stats(test.Type, test.Goal) {
+		if validVariation(test, stat.Variation) {
+			stats[stat.Variation] = stat
+		}
+	}
+
+	random := rand.New(rand.NewSource(int64(hashKey(id, test.Type+":adaptive", test.Salt))))
+
+	switch test.Mode {
+	case EpsilonGreedy:
+		epsilon := test.Epsilon
+		if epsilon == 0 {
+			epsilon = 0.1
+		}
+
+		if random.Float64() < epsilon {
+			return pickVariation(test, random.Intn(BucketCount))
+		}
+
+		return bestVariation(stats), nil
+	case ThompsonSampling:
+		best := 0
+		bestDraw := -1.0
+
+		for i, stat := range stats {
+			draw := sampleBeta(random, float64(stat.Conversions+1), float64(stat.Exposures-stat.Conversions+1))
+
+			if draw > bestDraw {
+				best = i
+				bestDraw = draw
+			}
+		}
+
+		return best, nil
+	}
+
+	return pickVariation(test, Bucket(id, test.Type, test.Salt))
+}
+
+// bestVariation returns the variation with the highest conversion rate.
+// Variations that have never been exposed are chosen first so each one gets tried
+func bestVariation(stats []VariationStats) int {
+	best := 0
+	bestRate := -1.0
+
+	for i, stat := range stats {
+		rate := stat.ConversionRate
+		if stat.Exposures == 0 {
+			rate = math.Inf(1)
+		}
+
+		if rate > bestRate {
+			best = i
+			bestRate = rate
+		}
+	}
+
+	return best
+}
+
+// sampleBeta draws a number from a beta(a, b) distribution
+func sampleBeta(random *rand.Rand, a, b float64) float64 {
+	x := sampleGamma(random, a)
+	y := sampleGamma(random, b)
+
+	return x / (x + y)
+}
+
+// sampleGamma draws a number from a gamma(shape, 1) distribution
+// using the Marsaglia and Tsang method
+func sampleGamma(random *rand.Rand, shape float64) float64 {
+	if shape < 1 {
+		return sampleGamma(random, shape+1) * math.Pow(random.Float64(), 1/shape)
+	}
+
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+
+	for {
+		x := random.NormFloat64()
+		v := 1 + c*x
+
+		if v <= 0 {
+			continue
+		}
+
+		v = v * v * v
+		u := random.Float64()
+
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ab
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// BucketCount is the number of buckets users are hashed into for each test
+const BucketCount = 10000
+
+/*
+Bucket returns the bucket (0 to BucketCount-1) an id falls into for a test.
+Each test is bucketed independently, so the variation a user receives in one
+test tells you nothing about the variation they receive in another, and the
+order tests are registered in does not matter.
+
+The algorithm is as follows, so it can be reproduced by other services:
+
+	key    = id + ":" + testType            (when salt is blank)
+	key    = id + ":" + testType + ":" + salt
+	hash   = SHA-256(key)
+	bucket = big endian uint64 of the first 8 bytes of hash, modulo BucketCount
+*/
+func Bucket(id, testType, salt string) int {
+	return int(hashKey(id, testType, salt) % BucketCount)
+}
+
+// hashKey returns the first 8 bytes of the SHA-256 hash of the bucketing key
+func hashKey(id, testType, salt string) uint64 {
+	key := id + ":" + testType
+	if salt != "" {
+		key += ":" + salt
+	}
+
+	hash := sha256.Sum256([]byte(key))
+
+	return binary.BigEndian.Uint64(hash[:8])
+}
+
+/*
+pickVariation maps a bucket onto a variation of the test.
+The bucket space is split into contiguous ranges, one per variation, sized by
+the variation's share of the total probability. Range boundaries are
+rounded down to whole buckets, with the last variation taking any remainder.
+When the test has no probabilities, every variation gets an equal share
+*/
+func pickVariation(test Test, bucket int) (int, error) {
+	if test.Probability == nil {
+		return bucket * len(test.Variations) / BucketCount, nil
+	}
+
+	if len(test.Probability) != len(test.Variations) {
+		return 0, fmt.Errorf("incorrect number of probabilities provided %s", test.Type)
+	}
+
+	var totalWeight float64
+
+	for _, prob := range test.Probability {
+		if prob < 0 {
+			return 0, fmt.Errorf("probability cannot be a negative number %s", test.Type)
+		}
+
+		totalWeight += prob
+	}
+
+	if totalWeight <= 0 {
+		return 0, fmt.Errorf("probabilities must add up to a positive number %s", test.Type)
+	}
+
+	var cumulative float64
+
+	for i := 0; i < len(test.Variations)-1; i++ {
+		cumulative += test.Probability[i]
+
+		if bucket < int(math.Floor(cumulative/totalWeight*BucketCount)) {
+			return i, nil
+		}
+	}
+
+	return len(test.Variations) - 1, nil
+}
@@ -0,0 +1,96 @@
+package ab
+
+import (
+	"time"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// EventType is the kind of event being recorded
+type EventType string
+
+const (
+	// EventExposure is emitted every time AB dispatches a variation to a user
+	EventExposure EventType = "exposure"
+
+	// EventConversion is emitted when a handler calls Convert
+	EventConversion EventType = "conversion"
+)
+
+// Event is a single exposure or conversion for a user
+type Event struct {
+	Type      EventType
+	TestType  string
+	Variation int
+	ID        string
+
+	// Goal is the name of the goal that was converted, it is blank for exposures
+	Goal string
+	Time time.Time
+}
+
+// EventSink is the interface that should be implemented by anything that
+// wants to receive ab exposure and conversion events
+type EventSink interface {
+	Record(event Event) error
+}
+
+// UseSink will make the tester send all exposure and conversion events to the given sink
+func (t *Tester) UseSink(sink EventSink) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.sink = sink
+}
+
+// Convert will record a conversion of the goal for the variation of testType
+// the user on the context is in
+func (t *Tester) Convert(c *gbl.Context, testType, goal string) {
+	abSuite := t.contextSuite(c)
+
+	variation, exists := abSuite[testType]
+	if !exists {
+		c.Errorf("Cannot convert goal %s for unregistered test type %s", goal, testType)
+		return
+	}
+
+	if _, overridden := t.ActiveOverrides(c)[testType]; overridden {
+		c.Tracef("Ignoring conversion of %s, AB test %s is overridden", goal, testType)
+		return
+	}
+
+	test := t.getTest(testType)
+
+	if test.Status == Concluded || !test.Running(time.Now()) {
+		c.Tracef("Ignoring conversion of %s, AB test %s is not running", goal, testType)
+		return
+	}
+
+	if variation == NotEnrolled || !eligible(c, test) {
+		c.Tracef("Ignoring conversion of %s, user is not enrolled in AB test %s", goal, testType)
+		return
+	}
+
+	t.emit(c, Event{
+		Type:      EventConversion,
+		TestType:  testType,
+		Variation: variation,
+		Goal:      goal,
+	})
+}
+
+// emit will send an event for the user on the context to the sink
+func (t *Tester) emit(c *gbl.Context, event Event) {
+	sink := t.eventSink()
+	if sink == nil {
+		return
+	}
+
+	event.ID = t.contextID(c)
+	event.Time = time.Now()
+
+	err := sink.Record(event)
+	if err != nil {
+		c.Errorf("Error recording AB %s event %v", event.Type, err)
+	}
+}
@@ -2,4 +2,8 @@ module github.com/calebhiebert/gobbl-extra/ab
 
 go 1.12
 
-require github.com/calebhiebert/gobbl v0.0.5
+require (
+	github.com/calebhiebert/gobbl v0.0.5
+	github.com/calebhiebert/gobbl/session v0.0.0-20190518200348-c7c97327009f
+	gopkg.in/yaml.v2 v2.2.2
+)
@@ -0,0 +1,242 @@
+package ab
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// Factor is a single dimension of a multivariate test, each level is a handler
+type Factor struct {
+	Name   string
+	Levels TestList
+}
+
+/*
+layerSelections returns the test each layer has selected for the id.
+The id is hashed into a bucket per layer (using "layer:" + layer name as the test type),
+and the bucket space is split between the layer's tests in order of their type.
+Each test takes a share equal to its Allocation, tests without an Allocation evenly
+split whatever is left. Layers whose shares add up to less than 1 leave some users
+outside of every test
+*/
+func layerSelections(tests []Test, id string) map[string]string {
+	selections := make(map[string]string)
+
+	for layer, layerTests := range groupLayers(tests) {
+		bucket := Bucket(id, "layer:"+layer, "")
+
+		shares := layerShares(layerTests)
+
+		var cumulative float64
+
+		for i, share := range shares {
+			cumulative += share.share
+
+			boundary := int(math.Floor(cumulative * BucketCount))
+
+			// Shares that add up to 1 can fall just short of it in floating point,
+			// the last test takes the remaining buckets like the last variation does
+			if i == len(shares)-1 && 1-cumulative < shareTolerance {
+				boundary = BucketCount
+			}
+
+			if bucket < boundary {
+				selections[layer] = share.testType
+				break
+			}
+		}
+	}
+
+	return selections
+}
+
+// shareTolerance is how far below 1 the shares of a layer can add up to
+// and still be treated as covering the whole layer
+const shareTolerance = 1e-9
+
+type layerShare struct {
+	testType string
+	share    float64
+}
+
+// layerShares returns the share of the layer each test takes, ordered by test type
+func layerShares(tests []Test) []layerShare {
+	sort.Slice(tests, func(i, j int) bool {
+		return tests[i].Type < tests[j].Type
+	})
+
+	var allocated float64
+	unallocated := 0
+
+	for _, test := range tests {
+		allocated += test.Allocation
+
+		if test.Allocation == 0 {
+			unallocated++
+		}
+	}
+
+	shares := []layerShare{}
+
+	for _, test := range tests {
+		share := test.Allocation
+		if share == 0 {
+			share = math.Max(1-allocated, 0) / float64(unallocated)
+		}
+
+		shares = append(shares, layerShare{testType: test.Type, share: share})
+	}
+
+	return shares
+}
+
+// groupLayers returns the tests of every layer keyed by layer name
+func groupLayers(tests []Test) map[string][]Test {
+	layers := make(map[string][]Test)
+
+	for _, test := range tests {
+		if test.Layer != "" {
+			layers[test.Layer] = append(layers[test.Layer], test)
+		}
+	}
+
+	return layers
+}
+
+// validateLayers returns an error if the allocations of any layer add up to more than 1
+func validateLayers(tests []Test) error {
+	for layer, layerTests := range groupLayers(tests) {
+		var total float64
+
+		for _, test := range layerTests {
+			total += test.Allocation
+		}
+
+		if total > 1 {
+			return fmt.Errorf("allocations of layer %s add up to more than 1", layer)
+		}
+	}
+
+	return nil
+}
+
+// enforceLayers makes sure a user that has already been exposed to a test in a layer stays
+// out of every other test in that layer, even if the layer's tests have changed since
+func enforceLayers(tests []Test, suite, recorded TestSuite) {
+	for _, layerTests := range groupLayers(tests) {
+		exposed := ""
+
+		for _, test := range layerTests {
+			if variation, exists := recorded[test.Type]; exists && variation != NotEnrolled {
+				exposed = test.Type
+				break
+			}
+		}
+
+		if exposed == "" {
+			continue
+		}
+
+		for _, test := range layerTests {
+			if test.Type != exposed {
+				suite[test.Type] = NotEnrolled
+			}
+		}
+	}
+}
+
+// FactorLevels returns the level of every factor that makes up a variation of a multivariate test
+func (test Test) FactorLevels(variation int) map[string]int {
+	levels := make(map[string]int)
+
+	for i := len(test.Factors) - 1; i >= 0; i-- {
+		factor := test.Factors[i]
+
+		levels[factor.Name] = variation % len(factor.Levels)
+		variation /= len(factor.Levels)
+	}
+
+	return levels
+}
+
+// validateFactors returns an error if any factor has no levels or a nil level
+func (test Test) validateFactors() error {
+	for _, factor := range test.Factors {
+		if len(factor.Levels) == 0 {
+			return fmt.Errorf("factor %s of test %s has no levels", factor.Name, test.Type)
+		}
+
+		for i, level := range factor.Levels {
+			if level == nil {
+				return fmt.Errorf("level %d of factor %s of test %s is nil", i, factor.Name, test.Type)
+			}
+		}
+	}
+
+	if len(test.Factors) > 0 && len(test.Variations) != factorCombinations(test.Factors) {
+		return fmt.Errorf("test %s must have one variation for every combination of factors", test.Type)
+	}
+
+	return nil
+}
+
+// factorCombinations returns the number of variations a set of factors produces
+func factorCombinations(factors []Factor) int {
+	combinations := 1
+
+	for _, factor := range factors {
+		combinations *= len(factor.Levels)
+	}
+
+	return combinations
+}
+
+// combineFactors generates a variation for every combination of factor levels.
+// Variations are numbered with the last factor changing fastest, and each variation
+// runs the handler of every factor's level in order, as a chain of middleware
+func combineFactors(factors []Factor) TestList {
+	variations := TestList{}
+
+	for variation := 0; variation < factorCombinations(factors); variation++ {
+		handlers := make([]gbl.MiddlewareFunction, len(factors))
+		remaining := variation
+
+		for i := len(factors) - 1; i >= 0; i-- {
+			handlers[i] = factors[i].Levels[remaining%len(factors[i].Levels)]
+			remaining /= len(factors[i].Levels)
+		}
+
+		variations = append(variations, chain(handlers))
+	}
+
+	return variations
+}
+
+// chain runs the handlers as if they were middleware, each handler's c.Next
+// calls the next handler and the last one continues the original chain
+func chain(handlers []gbl.MiddlewareFunction) gbl.MiddlewareFunction {
+	return func(c *gbl.Context) {
+		next := c.Next
+
+		var dispatch func(i int)
+
+		dispatch = func(i int) {
+			if i == len(handlers) {
+				c.Next = next
+				next()
+				return
+			}
+
+			c.Next = func() {
+				dispatch(i + 1)
+			}
+
+			handlers[i](c)
+		}
+
+		dispatch(0)
+	}
+}
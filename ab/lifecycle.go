@@ -0,0 +1,213 @@
+package ab
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/calebhiebert/gobbl"
+	"gopkg.in/yaml.v2"
+)
+
+// Status is the lifecycle stage of a test
+type Status string
+
+const (
+	// Draft tests are not running yet, everyone receives the control
+	Draft Status = "draft"
+
+	// Running tests enroll users and generate events
+	Running Status = "running"
+
+	// Paused tests are temporarily stopped, everyone receives the control
+	Paused Status = "paused"
+
+	// Concluded tests are finished, everyone receives the winning variation
+	Concluded Status = "concluded"
+)
+
+// Config holds the lifecycle state of a set of tests, it can be loaded from a JSON or YAML file
+type Config struct {
+	Tests []TestConfig `json:"tests" yaml:"tests"`
+}
+
+// TestConfig holds the lifecycle state of a single test
+type TestConfig struct {
+	Type   string    `json:"type" yaml:"type"`
+	Status Status    `json:"status" yaml:"status"`
+	Start  time.Time `json:"start" yaml:"start"`
+	End    time.Time `json:"end" yaml:"end"`
+	Winner int       `json:"winner" yaml:"winner"`
+}
+
+// Running returns true if the test is enrolling users at the given time
+func (test Test) Running(now time.Time) bool {
+	if test.Status != "" && test.Status != Running {
+		return false
+	}
+
+	if !test.Start.IsZero() && now.Before(test.Start) {
+		return false
+	}
+
+	if !test.End.IsZero() && !now.Before(test.End) {
+		return false
+	}
+
+	return true
+}
+
+// validateLifecycle returns an error if the lifecycle settings are invalid
+func (test Test) validateLifecycle() error {
+	switch test.Status {
+	case "", Draft, Running, Paused:
+	case Concluded:
+		if test.Winner != NotEnrolled && !validVariation(test, test.Winner) {
+			return fmt.Errorf("winner %d is not a variation of test %s", test.Winner, test.Type)
+		}
+	default:
+		return fmt.Errorf("unknown status %s for test %s", test.Status, test.Type)
+	}
+
+	if !test.Start.IsZero() && !test.End.IsZero() && !test.End.After(test.Start) {
+		return fmt.Errorf("end must be after start for test %s", test.Type)
+	}
+
+	return nil
+}
+
+// winner returns the handler everyone receives once the test is concluded
+func winner(test Test) gbl.MiddlewareFunction {
+	if test.Winner == NotEnrolled {
+		return control(test)
+	}
+
+	return test.Variations[test.Winner]
+}
+
+// SetStatus will change the status of a registered test
+func (t *Tester) SetStatus(testType string, status Status) error {
+	return t.updateTest(testType, func(test *Test) {
+		test.Status = status
+	})
+}
+
+// SetWindow will change the time a registered test is running, zero values are ignored
+func (t *Tester) SetWindow(testType string, start, end time.Time) error {
+	return t.updateTest(testType, func(test *Test) {
+		test.Start = start
+		test.End = end
+	})
+}
+
+// DeclareWinner will conclude a registered test, from then on everyone receives the
+// winning variation. Use NotEnrolled to send everyone to the control
+func (t *Tester) DeclareWinner(testType string, variation int) error {
+	return t.updateTest(testType, func(test *Test) {
+		test.Status = Concluded
+		test.Winner = variation
+	})
+}
+
+// ApplyConfig will update the lifecycle state of every test in the config.
+// Nothing is changed if any of the tests are invalid
+func (t *Tester) ApplyConfig(config Config) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	updated := append([]Test{}, t.testArr...)
+
+	for _, testConfig := range config.Tests {
+		idx := findTest(updated, testConfig.Type)
+		if idx == -1 {
+			return fmt.Errorf("test type %s was not registered", testConfig.Type)
+		}
+
+		updated[idx].Status = testConfig.Status
+		updated[idx].Start = testConfig.Start
+		updated[idx].End = testConfig.End
+		updated[idx].Winner = testConfig.Winner
+
+		err := updated[idx].Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := validateLayers(updated)
+	if err != nil {
+		return err
+	}
+
+	t.testArr = updated
+
+	return nil
+}
+
+// LoadConfig will read a JSON or YAML (.yaml or .yml) config file and apply it
+func (t *Tester) LoadConfig(path string) error {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config Config
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(file, &config)
+	default:
+		err = json.Unmarshal(file, &config)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return t.ApplyConfig(config)
+}
+
+// updateTest will apply a change to a registered test,
+// the change is discarded if the test is no longer valid
+func (t *Tester) updateTest(testType string, update func(test *Test)) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	idx := findTest(t.testArr, testType)
+	if idx == -1 {
+		return fmt.Errorf("test type %s was not registered", testType)
+	}
+
+	test := t.testArr[idx]
+	update(&test)
+
+	err := test.Validate()
+	if err != nil {
+		return err
+	}
+
+	updated := append([]Test{}, t.testArr...)
+	updated[idx] = test
+
+	err = validateLayers(updated)
+	if err != nil {
+		return err
+	}
+
+	t.testArr = updated
+
+	return nil
+}
+
+// findTest returns the index of the test with the given type, or -1
+func findTest(tests []Test, testType string) int {
+	for i, test := range tests {
+		if test.Type == testType {
+			return i
+		}
+	}
+
+	return -1
+}
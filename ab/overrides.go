@@ -0,0 +1,193 @@
+package ab
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// forcedFlag is the session flag that stores variations forced for the session
+var forcedFlag = "sess:_abForced"
+
+// Override will always give the user with the given id the variation of testType.
+// Overridden users are not recorded in the assignment store and do not generate events
+func (t *Tester) Override(id, testType string, variation int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, exists := t.overrides[id]; !exists {
+		t.overrides[id] = TestSuite{}
+	}
+
+	t.overrides[id][testType] = variation
+}
+
+// ClearOverride will remove the override of testType for the user with the given id,
+// if no test types are supplied every override for the user is removed
+func (t *Tester) ClearOverride(id string, testTypes ...string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(testTypes) == 0 {
+		delete(t.overrides, id)
+		return
+	}
+
+	for _, testType := range testTypes {
+		delete(t.overrides[id], testType)
+	}
+
+	if len(t.overrides[id]) == 0 {
+		delete(t.overrides, id)
+	}
+}
+
+// Overrides returns a copy of every static override, keyed by user id
+func (t *Tester) Overrides() map[string]TestSuite {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	overrides := make(map[string]TestSuite)
+
+	for id, suite := range t.overrides {
+		overrides[id] = TestSuite{}
+
+		for testType, variation := range suite {
+			overrides[id][testType] = variation
+		}
+	}
+
+	return overrides
+}
+
+// ActiveOverrides returns every override that applies to the user on the context.
+// Variations forced for the session take precedence over static overrides
+func (t *Tester) ActiveOverrides(c *gbl.Context) TestSuite {
+	active := t.userOverrides(t.contextID(c))
+
+	for testType, variation := range Forced(c) {
+		active[testType] = variation
+	}
+
+	return active
+}
+
+// userOverrides returns a copy of the static overrides for an id
+func (t *Tester) userOverrides(id string) TestSuite {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	overrides := TestSuite{}
+
+	for testType, variation := range t.overrides[id] {
+		overrides[testType] = variation
+	}
+
+	return overrides
+}
+
+// Force will force the variation of testType for the rest of the user's session.
+// This requires the session middleware to be installed
+func Force(c *gbl.Context, testType string, variation int) {
+	forced := forcedMap(c)
+	forced[testType] = variation
+
+	c.Flag(forcedFlag, forced)
+}
+
+// ClearForced will remove the forced variation of the given test types for the session,
+// if no test types are supplied every forced variation is removed
+func ClearForced(c *gbl.Context, testTypes ...string) {
+	if len(testTypes) == 0 {
+		c.ClearFlag(forcedFlag)
+		return
+	}
+
+	forced := forcedMap(c)
+
+	for _, testType := range testTypes {
+		delete(forced, testType)
+	}
+
+	c.Flag(forcedFlag, forced)
+}
+
+// Forced returns the variations that have been forced for the session
+func Forced(c *gbl.Context) TestSuite {
+	forced := TestSuite{}
+
+	for testType, value := range forcedMap(c) {
+		variation, err := toInt(value)
+		if err != nil {
+			c.Warnf("Ignoring invalid forced variation for AB test %s %v", testType, err)
+			continue
+		}
+
+		forced[testType] = variation
+	}
+
+	return forced
+}
+
+// forcedMap returns a copy of the forced variations stored in the session
+func forcedMap(c *gbl.Context) map[string]interface{} {
+	forced := make(map[string]interface{})
+
+	if !c.HasFlag(forcedFlag) {
+		return forced
+	}
+
+	if stored, ok := c.GetFlag(forcedFlag).(map[string]interface{}); ok {
+		for testType, value := range stored {
+			forced[testType] = value
+		}
+	}
+
+	return forced
+}
+
+/*
+CommandMiddleware returns a middleware that lets users force variations with a chat command.
+Since anyone can use the command, it should only be installed in development or QA builds.
+When a command is handled, the rest of the middleware chain is skipped
+
+	/ab greeting 2      forces variation 2 of the greeting test
+	/ab greeting reset  removes the forced variation of the greeting test
+	/ab reset           removes every forced variation
+*/
+func (t *Tester) CommandMiddleware(command string) gbl.MiddlewareFunction {
+	return func(c *gbl.Context) {
+		args := strings.Fields(c.Request.Text)
+
+		if len(args) == 0 || args[0] != command {
+			c.Next()
+			return
+		}
+
+		switch {
+		case len(args) == 2 && args[1] == "reset":
+			ClearForced(c)
+			c.Info("Cleared all forced AB variations")
+		case len(args) == 3 && args[2] == "reset":
+			ClearForced(c, args[1])
+			c.Infof("Cleared forced variation of AB test %s", args[1])
+		case len(args) == 3:
+			variation, err := strconv.Atoi(args[2])
+			if err != nil || !validVariation(t.getTest(args[1]), variation) {
+				c.Warnf("Invalid AB command %s", c.Request.Text)
+				return
+			}
+
+			Force(c, args[1], variation)
+			c.Infof("Forced variation %d of AB test %s", variation, args[1])
+		default:
+			c.Warnf("Invalid AB command %s", c.Request.Text)
+		}
+	}
+}
+
+// validVariation returns true if the variation exists in the test
+func validVariation(test Test, variation int) bool {
+	return variation >= 0 && variation < len(test.Variations)
+}
@@ -0,0 +1,177 @@
+package ab
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/calebhiebert/gobbl/session"
+)
+
+// AssignmentStore is the interface that should be implemented by anything
+// that can persist the variations a user has been assigned
+type AssignmentStore interface {
+
+	/*
+		Returns every assignment recorded for the given id
+		Should return an empty suite (not an error) if nothing has been recorded yet
+	*/
+	Get(id string) (TestSuite, error)
+
+	/*
+		Records assignments for the given id
+		Save should merge the supplied assignments with any existing ones
+	*/
+	Save(id string, suite TestSuite) error
+}
+
+type memoryStore struct {
+	suites map[string]TestSuite
+	mutex  *sync.Mutex
+}
+
+// MemoryStore creates a new in-memory assignment store
+func MemoryStore() *memoryStore {
+	return &memoryStore{
+		suites: make(map[string]TestSuite),
+		mutex:  &sync.Mutex{},
+	}
+}
+
+// Get returns a copy of the assignments stored for the id
+func (m *memoryStore) Get(id string) (TestSuite, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	suite := TestSuite{}
+
+	for testType, variation := range m.suites[id] {
+		suite[testType] = variation
+	}
+
+	return suite, nil
+}
+
+// Save merges the assignments into the ones stored for the id
+func (m *memoryStore) Save(id string, suite TestSuite) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stored, exists := m.suites[id]
+	if !exists {
+		stored = TestSuite{}
+		m.suites[id] = stored
+	}
+
+	for testType, variation := range suite {
+		stored[testType] = variation
+	}
+
+	return nil
+}
+
+type sessionStore struct {
+	store     sess.SessionStore
+	keyPrefix string
+	mutex     *sync.Mutex
+}
+
+// SessionStore creates an assignment store that persists assignments in a gobbl session store.
+// Assignments are saved under their own session entry (keyPrefix + id) so they
+// are not overwritten by the session middleware
+func SessionStore(store sess.SessionStore, keyPrefix string) *sessionStore {
+	return &sessionStore{
+		store:     store,
+		keyPrefix: keyPrefix,
+		mutex:     &sync.Mutex{},
+	}
+}
+
+// Get loads the assignments for the id from the session store
+func (s *sessionStore) Get(id string) (TestSuite, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := s.store.Get(s.keyPrefix + id)
+	if err != nil {
+		if err == sess.ErrSessionNonexistant {
+			return TestSuite{}, nil
+		}
+
+		return nil, err
+	}
+
+	suite := TestSuite{}
+
+	for testType, value := range data {
+		variation, err := toInt(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stored variation for test %s: %v", testType, err)
+		}
+
+		suite[testType] = variation
+	}
+
+	return suite, nil
+}
+
+// Save merges the assignments into the session stored for the id. The merge is only
+// atomic within this process, processes sharing the session store can overwrite
+// each other's assignments if they save for the same id at the same time
+func (s *sessionStore) Save(id string, suite TestSuite) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := s.store.Get(s.keyPrefix + id)
+	if err != nil {
+		if err != sess.ErrSessionNonexistant {
+			return err
+		}
+
+		data = make(map[string]interface{})
+	}
+
+	// Stores like the memory store return the map they hold, so it is copied
+	// instead of being changed outside of the store's lock
+	merged := make(map[string]interface{})
+
+	for key, value := range data {
+		merged[key] = value
+	}
+
+	for testType, variation := range suite {
+		merged[testType] = variation
+	}
+
+	return s.store.Update(s.keyPrefix+id, &merged)
+}
+
+// toInt converts a number loaded from a session store into an int,
+// stores like redis will return whatever numeric type they decoded
+func toInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int8:
+		return int(v), nil
+	case int16:
+		return int(v), nil
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case uint8:
+		return int(v), nil
+	case uint16:
+		return int(v), nil
+	case uint32:
+		return int(v), nil
+	case uint64:
+		return int(v), nil
+	case float32:
+		return int(v), nil
+	case float64:
+		return int(v), nil
+	}
+
+	return 0, fmt.Errorf("unsupported type %T", value)
+}
@@ -0,0 +1,89 @@
+package ab
+
+import (
+	"math"
+	"reflect"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// TargetingRule decides if the user on the context is eligible for a test
+type TargetingRule func(c *gbl.Context) bool
+
+// FlagEquals will match if the flag is set on the context with the given string value
+func FlagEquals(flag, value string) TargetingRule {
+	return func(c *gbl.Context) bool {
+		if !c.HasFlag(flag) {
+			return false
+		}
+
+		flagValue, ok := c.GetFlag(flag).(string)
+
+		return ok && flagValue == value
+	}
+}
+
+// Lang will match if the "lang" flag is any of the supplied languages
+func Lang(langs ...string) TargetingRule {
+	return func(c *gbl.Context) bool {
+		for _, lang := range langs {
+			if FlagEquals("lang", lang)(c) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// IntegrationType will match if the request came in through an integration of the same type
+// as any of the supplied integrations
+func IntegrationType(integrations ...gbl.Integration) TargetingRule {
+	return func(c *gbl.Context) bool {
+		for _, integration := range integrations {
+			if reflect.TypeOf(c.Integration) == reflect.TypeOf(integration) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// UserMatches will match if the function returns true for the user on the context
+func UserMatches(match func(user gbl.User) bool) TargetingRule {
+	return func(c *gbl.Context) bool {
+		return match(c.User)
+	}
+}
+
+// eligible returns true if every rule of the test passes for the context
+func eligible(c *gbl.Context, test Test) bool {
+	for _, rule := range test.Rules {
+		if !rule(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// allocated returns true if the id falls within the test's traffic allocation.
+// Allocation uses its own bucket (the test type suffixed with ":allocation") so
+// enrollment is independent of which variation a user receives
+func allocated(test Test, id string) bool {
+	if test.Allocation == 0 {
+		return true
+	}
+
+	return Bucket(id, test.Type+":allocation", test.Salt) < int(math.Floor(test.Allocation*BucketCount))
+}
+
+// control returns the handler users that are not enrolled in the test receive
+func control(test Test) gbl.MiddlewareFunction {
+	if test.Control != nil {
+		return test.Control
+	}
+
+	return test.Variations[0]
+}
@@ -0,0 +1,58 @@
+package bctx
+
+import (
+	"reflect"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// middlewareCode, routerCode, exportCode and importCode identify the functions generated by
+// MiddlewareWithConfig, RContextIntentRouter.Middleware, ExportDialogflow and ImportDialogflow
+var (
+	middlewareCode = funcCode(MiddlewareWithConfig(&MiddlewareConfig{}))
+	routerCode     = funcCode(ContextIntentRouter().Middleware())
+	exportCode     = funcCode(ExportDialogflow())
+	importCode     = funcCode(ImportDialogflow())
+)
+
+/*
+CheckChain should be called at startup with the middlewares in the order they are
+given to the bot. It returns ErrMissingMiddleware if a context router or dialogflow
+bridge is used without the context middleware running before it
+
+	middlewares := []gbl.MiddlewareFunction{
+		sess.Middleware(store),
+		bctx.Middleware(),
+		router.Middleware(),
+	}
+
+	if err := bctx.CheckChain(middlewares...); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, middleware := range middlewares {
+		bot.Use(middleware)
+	}
+*/
+func CheckChain(middlewares ...gbl.MiddlewareFunction) error {
+	contextInstalled := false
+
+	for _, middleware := range middlewares {
+		switch funcCode(middleware) {
+		case middlewareCode:
+			contextInstalled = true
+		case routerCode, exportCode, importCode:
+			if !contextInstalled {
+				return ErrMissingMiddleware
+			}
+		}
+	}
+
+	return nil
+}
+
+// funcCode returns the code pointer of a function, every closure created
+// by the same function literal shares a code pointer
+func funcCode(f gbl.MiddlewareFunction) uintptr {
+	return reflect.ValueOf(f).Pointer()
+}
@@ -0,0 +1,112 @@
+package bctx
+
+import (
+	"fmt"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// Condition is a check a route must pass before its handler is called
+type Condition func(c *gbl.Context, botContext *BotContext) bool
+
+// HasAny passes if any of the contexts are present
+func HasAny(contexts C) Condition {
+	return func(c *gbl.Context, botContext *BotContext) bool {
+		return hasAnyContexts(botContext, contexts)
+	}
+}
+
+// HasAll passes if all of the contexts are present
+func HasAll(contexts C) Condition {
+	return func(c *gbl.Context, botContext *BotContext) bool {
+		return hasAllContexts(botContext, contexts)
+	}
+}
+
+// HasNoContexts passes if the user has no contexts at all
+func HasNoContexts() Condition {
+	return func(c *gbl.Context, botContext *BotContext) bool {
+		return len(botContext.Contexts) == 0
+	}
+}
+
+// DataEquals passes if the context exists and its data param is the given string
+func DataEquals(contextName, dataParam, value string) Condition {
+	return func(c *gbl.Context, botContext *BotContext) bool {
+		data, ok := Lookup(c, contextName, dataParam)
+		return ok && data.String() == value
+	}
+}
+
+// HasData passes if the context exists and has the data param set
+func HasData(contextName, dataParam string) Condition {
+	return func(c *gbl.Context, botContext *BotContext) bool {
+		_, ok := Lookup(c, contextName, dataParam)
+		return ok
+	}
+}
+
+// HasEntity passes if the NLU found the entity, either as a LUIS
+// entity (luis:e:name) or as a non empty dialogflow parameter (dflow:p:name)
+func HasEntity(name string) Condition {
+	return func(c *gbl.Context, botContext *BotContext) bool {
+		return len(entityValues(c, name)) > 0
+	}
+}
+
+// EntityEquals passes if any of the values the NLU found for the entity is the given value
+func EntityEquals(name, value string) Condition {
+	return func(c *gbl.Context, botContext *BotContext) bool {
+		for _, entityValue := range entityValues(c, name) {
+			if entityValue == value {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// MinScore passes if the intent:score flag is at least score,
+// it never passes if the NLU did not set a score
+func MinScore(score float64) Condition {
+	return func(c *gbl.Context, botContext *BotContext) bool {
+		if !c.HasFlag("intent:score") {
+			return false
+		}
+
+		intentScore, ok := c.GetFlag("intent:score").(float64)
+
+		return ok && intentScore >= score
+	}
+}
+
+// entityValues returns every value set for the entity by the LUIS or dialogflow middleware
+func entityValues(c *gbl.Context, name string) []string {
+	values := []string{}
+
+	if c.HasFlag("luis:e:" + name) {
+		if luisValues, ok := c.GetFlag("luis:e:" + name).([]string); ok {
+			values = append(values, luisValues...)
+		}
+	}
+
+	if c.HasFlag("dflow:p:" + name) {
+		switch param := c.GetFlag("dflow:p:" + name).(type) {
+		case []interface{}:
+			for _, v := range param {
+				values = append(values, fmt.Sprint(v))
+			}
+		case string:
+			// Dialogflow sends unfilled parameters as blank strings
+			if param != "" {
+				values = append(values, param)
+			}
+		case nil:
+		default:
+			values = append(values, fmt.Sprint(param))
+		}
+	}
+
+	return values
+}
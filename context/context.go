@@ -1,6 +1,11 @@
 package bctx
 
 import (
+	"bytes"
+	"errors"
+	"sort"
+	"time"
+
 	"github.com/calebhiebert/gobbl"
 )
 
@@ -10,83 +15,266 @@ var flagKeyName = "_bctxDecoded"
 type BotContext struct {
 	Sequence int                  `json:"s"`
 	Contexts map[string]BCContext `json:"c"`
+
+	// LastSeen is the unix time (in seconds) of the user's last request
+	LastSeen int64 `json:"ls,omitempty"`
+
+	// Dialogs is the dialog stack, the last dialog is the current one
+	Dialogs []Dialog `json:"ds,omitempty"`
+
+	// History is the most recent changes to the contexts, oldest first
+	History []HistoryEntry `json:"h,omitempty"`
+
+	// hooks are the lifecycle hooks of the middleware that decoded the context
+	hooks *LifecycleHooks
+
+	// historySize is the number of history entries that are kept
+	historySize int
 }
 
 // BCContext represents a single context item
 type BCContext struct {
-	Name            string            `json:"n"`
-	Source          string            `json:"s"`
-	BirthSequence   int               `json:"bs"`
-	CurrentLifetime int               `json:"cl"`
-	Data            map[string]string `json:"d"`
+	Name            string           `json:"n"`
+	Source          string           `json:"s"`
+	BirthSequence   int              `json:"bs"`
+	CurrentLifetime int              `json:"cl"`
+	Data            map[string]Value `json:"d"`
+
+	// ExpiresAt is the unix time (in seconds) the context expires at, 0 if
+	// the context only expires by lifetime
+	ExpiresAt int64 `json:"ea,omitempty"`
+}
+
+// now is used to get the current time, it can be replaced in tests
+var now = time.Now
+
+// unixCeil returns the unix time (in seconds) of t rounded up, times are stored
+// in whole seconds so rounding up keeps durations from being cut short
+func unixCeil(t time.Time) int64 {
+	if t.Nanosecond() > 0 {
+		return t.Unix() + 1
+	}
+
+	return t.Unix()
+}
+
+// ErrMissingMiddleware is returned when the context middleware has not run before a context function is used
+var ErrMissingMiddleware = errors.New("bctx middleware is not installed, or has not run yet")
+
+// Current returns the bot context for the current request
+func Current(c *gbl.Context) (*BotContext, error) {
+	if !c.HasFlag(flagKeyName) {
+		return nil, ErrMissingMiddleware
+	}
+
+	botContext, ok := c.GetFlag(flagKeyName).(*BotContext)
+	if !ok {
+		return nil, ErrMissingMiddleware
+	}
+
+	return botContext, nil
+}
+
+// names returns the names of the active contexts in order
+func (botContext *BotContext) names() []string {
+	names := []string{}
+
+	for name := range botContext.Contexts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
 }
 
 // ClearAll will clear all contexts for the current session
-func ClearAll(c *gbl.Context) {
-	if c.HasFlag(flagKeyName) {
-		botContext := c.GetFlag(flagKeyName).(*BotContext)
-		botContext.Contexts = make(map[string]BCContext)
-	} else {
-		panic("Missing _bctxDecoded!")
+func ClearAll(c *gbl.Context) error {
+	botContext, err := Current(c)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range botContext.names() {
+		botContext.record(HistoryRemoved, name, botContext.Contexts[name].Source, "")
 	}
+
+	botContext.Contexts = make(map[string]BCContext)
+
+	return nil
 }
 
 // Clear will clear the context with the given name from the session (if it exists)
-func Clear(c *gbl.Context, contextName string) {
-	if c.HasFlag(flagKeyName) {
-		botContext := c.GetFlag(flagKeyName).(*BotContext)
+func Clear(c *gbl.Context, contextName string) error {
+	botContext, err := Current(c)
+	if err != nil {
+		return err
+	}
+
+	if ctx, exists := botContext.Contexts[contextName]; exists {
+		botContext.record(HistoryRemoved, contextName, ctx.Source, "")
 		delete(botContext.Contexts, contextName)
-	} else {
-		panic("Missing _bctxDecoded!")
 	}
+
+	return nil
 }
 
 // Add will add a new context to the session
-func Add(c *gbl.Context, name string, lifetime int) {
-	AddSourced(c, name, lifetime, "none")
+func Add(c *gbl.Context, name string, lifetime int) error {
+	return AddSourced(c, name, lifetime, "none")
 }
 
 // AddSourced will add a new context to the session with an optional source param
 // this is mostly helpful for debugging, since you can see where the context was added
-func AddSourced(c *gbl.Context, name string, lifetime int, source string) {
-	if c.HasFlag(flagKeyName) {
-		botContext := c.GetFlag(flagKeyName).(*BotContext)
-		botContext.Contexts[name] = BCContext{
-			Name:            name,
-			BirthSequence:   botContext.Sequence,
-			CurrentLifetime: lifetime,
-			Source:          source,
-			Data:            make(map[string]string),
-		}
-	} else {
-		panic("Missing _bctxDecoded!")
+func AddSourced(c *gbl.Context, name string, lifetime int, source string) error {
+	return AddTimed(c, name, lifetime, 0, source)
+}
+
+// AddTimed will add a new context to the session that also expires once ttl has passed.
+// The context expires by turns or by time, whichever comes first. Use a lifetime of -1
+// to only expire by time, and a ttl of 0 to only expire by turns. Expiry times are kept
+// in whole seconds, the ttl is rounded up so the context never expires early
+func AddTimed(c *gbl.Context, name string, lifetime int, ttl time.Duration, source string) error {
+	botContext, err := Current(c)
+	if err != nil {
+		return err
+	}
+
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = unixCeil(now().Add(ttl))
 	}
+
+	ctx := BCContext{
+		Name:            name,
+		BirthSequence:   botContext.Sequence,
+		CurrentLifetime: lifetime,
+		Source:          source,
+		Data:            make(map[string]Value),
+		ExpiresAt:       expiresAt,
+	}
+
+	botContext.Contexts[name] = ctx
+	botContext.record(HistoryAdded, name, source, "")
+	botContext.hooks.entered(c, ctx)
+
+	return nil
 }
 
-// Get will return the context data param stored at the context key value pair location
+// Get will return the context data param stored at the context key value pair location.
+// Values that are not strings are returned as JSON
 func Get(c *gbl.Context, contextName, dataParam string) string {
-	if c.HasFlag(flagKeyName) {
-		botContext := c.GetFlag(flagKeyName).(*BotContext)
+	value, _ := Lookup(c, contextName, dataParam)
+	return value.String()
+}
 
-		ctx, exists := botContext.Contexts[contextName]
-		if !exists {
-			return ""
-		}
+// Lookup will return the context data param stored at the context key value pair location,
+// ok is false if the context or the data param does not exist
+func Lookup(c *gbl.Context, contextName, dataParam string) (value Value, ok bool) {
+	botContext, err := Current(c)
+	if err != nil {
+		return Value{}, false
+	}
+
+	ctx, exists := botContext.Contexts[contextName]
+	if !exists {
+		return Value{}, false
+	}
+
+	value, ok = ctx.Data[dataParam]
+	return value, ok
+}
+
+// GetInt will return the context data param as an int, ok is false if the
+// data param does not exist or is not a number
+func GetInt(c *gbl.Context, contextName, dataParam string) (int, bool) {
+	value, ok := Lookup(c, contextName, dataParam)
+	if !ok {
+		return 0, false
+	}
+
+	i, err := value.Int()
+	return i, err == nil
+}
+
+// GetFloat will return the context data param as a float64, ok is false if the
+// data param does not exist or is not a number
+func GetFloat(c *gbl.Context, contextName, dataParam string) (float64, bool) {
+	value, ok := Lookup(c, contextName, dataParam)
+	if !ok {
+		return 0, false
+	}
+
+	f, err := value.Float()
+	return f, err == nil
+}
 
-		return ctx.Data[dataParam]
+// GetBool will return the context data param as a bool, ok is false if the
+// data param does not exist or is not a boolean
+func GetBool(c *gbl.Context, contextName, dataParam string) (bool, bool) {
+	value, ok := Lookup(c, contextName, dataParam)
+	if !ok {
+		return false, false
 	}
 
-	return ""
+	b, err := value.Bool()
+	return b, err == nil
+}
+
+// GetJSON will decode the context data param into a T, ok is false if the
+// data param does not exist or cannot be decoded into a T
+func GetJSON[T any](c *gbl.Context, contextName, dataParam string) (T, bool) {
+	var out T
+
+	value, ok := Lookup(c, contextName, dataParam)
+	if !ok {
+		return out, false
+	}
+
+	err := value.Decode(&out)
+	return out, err == nil
 }
 
 // Set will set a key value pair on the context
 func Set(c *gbl.Context, contextName, dataParam, dataValue string) {
-	if c.HasFlag(flagKeyName) {
-		botContext := c.GetFlag(flagKeyName).(*BotContext)
+	SetValue(c, contextName, dataParam, StringValue(dataValue))
+}
+
+// SetJSON will JSON encode v and set it on the context,
+// use this to store numbers, booleans, lists or structs
+func SetJSON(c *gbl.Context, contextName, dataParam string, v interface{}) error {
+	value, err := NewValue(v)
+	if err != nil {
+		return err
+	}
 
-		ctx, exists := botContext.Contexts[contextName]
-		if exists {
-			ctx.Data[dataParam] = dataValue
+	SetValue(c, contextName, dataParam, value)
+
+	return nil
+}
+
+// SetValue will set a key value pair on the context
+func SetValue(c *gbl.Context, contextName, dataParam string, value Value) {
+	botContext, err := Current(c)
+	if err != nil {
+		return
+	}
+
+	ctx, exists := botContext.Contexts[contextName]
+	if exists {
+		if ctx.Data == nil {
+			ctx.Data = make(map[string]Value)
+			botContext.Contexts[contextName] = ctx
 		}
+
+		previous, existed := ctx.Data[dataParam]
+		ctx.Data[dataParam] = value
+
+		if existed && bytes.Equal(previous.Raw(), value.Raw()) {
+			return
+		}
+
+		botContext.record(HistoryChanged, contextName, ctx.Source, dataParam)
+		botContext.hooks.changed(c, ctx, dataParam, previous, existed)
 	}
 }
@@ -0,0 +1,943 @@
+package bctx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// newTestContext creates a gobbl context with the given encoded session context
+func newTestContext(encoded string) *gbl.Context {
+	c := gbl.InputContext{}.Transform(gbl.New())
+	c.Next = func() {}
+
+	if encoded != "" {
+		c.Flag("sess:_bctx", encoded)
+	}
+
+	return c
+}
+
+// run will run the handler inside of the context middleware
+func run(c *gbl.Context, handler gbl.MiddlewareFunction) {
+	c.Next = func() {
+		c.Next = func() {}
+		handler(c)
+	}
+
+	Middleware()(c)
+}
+
+func TestTypedValues(t *testing.T) {
+	// A session encoded before data params were typed
+	c := newTestContext(`{"s":1,"c":{"booking":{"n":"booking","s":"none","bs":1,"cl":5,"d":{"name":"Sam","size":"4","vip":"true"}}}}`)
+
+	type Party struct {
+		Names []string `json:"names"`
+	}
+
+	run(c, func(c *gbl.Context) {
+		if Get(c, "booking", "name") != "Sam" {
+			t.Errorf("Expected old string value, got %s", Get(c, "booking", "name"))
+		}
+
+		if size, ok := GetInt(c, "booking", "size"); !ok || size != 4 {
+			t.Errorf("Expected old string number to be parsed, got %d %v", size, ok)
+		}
+
+		if vip, ok := GetBool(c, "booking", "vip"); !ok || !vip {
+			t.Errorf("Expected old string boolean to be parsed, got %v %v", vip, ok)
+		}
+
+		if _, ok := Lookup(c, "booking", "missing"); ok {
+			t.Error("Lookup should report missing params")
+		}
+
+		SetJSON(c, "booking", "size", 6)
+		SetJSON(c, "booking", "party", Party{Names: []string{"Sam", "Alex"}})
+	})
+
+	run(c, func(c *gbl.Context) {
+		if size, ok := GetInt(c, "booking", "size"); !ok || size != 6 {
+			t.Errorf("Expected typed number to round trip, got %d %v", size, ok)
+		}
+
+		party, ok := GetJSON[Party](c, "booking", "party")
+		if !ok || len(party.Names) != 2 {
+			t.Errorf("Expected struct to round trip, got %+v %v", party, ok)
+		}
+
+		if Get(c, "booking", "size") != "6" {
+			t.Errorf("Expected Get to return the JSON of non string values, got %s", Get(c, "booking", "size"))
+		}
+	})
+}
+
+func TestTimedExpiry(t *testing.T) {
+	current := time.Unix(1500000000, 0)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	c := newTestContext("")
+	middleware := MiddlewareWithConfig(&MiddlewareConfig{IdleTimeout: 24 * time.Hour})
+
+	handle := func(handler gbl.MiddlewareFunction) {
+		c.Next = func() {
+			c.Next = func() {}
+			handler(c)
+		}
+
+		middleware(c)
+	}
+
+	handle(func(c *gbl.Context) {
+		AddTimed(c, "booking", -1, time.Hour, "test")
+		AddTimed(c, "menu", 10, 0, "test")
+	})
+
+	current = current.Add(30 * time.Minute)
+
+	handle(func(c *gbl.Context) {
+		botContext := c.GetFlag(flagKeyName).(*BotContext)
+		if _, exists := botContext.Contexts["booking"]; !exists {
+			t.Error("Context should not have expired before its ttl")
+		}
+	})
+
+	current = current.Add(time.Hour)
+
+	handle(func(c *gbl.Context) {
+		botContext := c.GetFlag(flagKeyName).(*BotContext)
+		if _, exists := botContext.Contexts["booking"]; exists {
+			t.Error("Context should have expired after its ttl")
+		}
+
+		if _, exists := botContext.Contexts["menu"]; !exists {
+			t.Error("Contexts without a ttl should not expire by time")
+		}
+	})
+
+	current = current.Add(72 * time.Hour)
+
+	handle(func(c *gbl.Context) {
+		botContext := c.GetFlag(flagKeyName).(*BotContext)
+		if len(botContext.Contexts) != 0 {
+			t.Errorf("Every context should expire after the idle timeout %+v", botContext.Contexts)
+		}
+	})
+
+	// Durations under a second are rounded up instead of expiring on the next request
+	current = time.Unix(1500000000, int64(900*time.Millisecond))
+	middleware = MiddlewareWithConfig(&MiddlewareConfig{IdleTimeout: 500 * time.Millisecond})
+
+	handle(func(c *gbl.Context) {
+		AddTimed(c, "booking", -1, 500*time.Millisecond, "test")
+	})
+
+	current = current.Add(200 * time.Millisecond)
+
+	handle(func(c *gbl.Context) {
+		botContext := c.GetFlag(flagKeyName).(*BotContext)
+		if _, exists := botContext.Contexts["booking"]; !exists {
+			t.Error("Contexts should not expire before a ttl under one second")
+		}
+	})
+}
+
+func TestMissingMiddleware(t *testing.T) {
+	c := newTestContext("")
+
+	if err := Add(c, "booking", 5); err != ErrMissingMiddleware {
+		t.Errorf("Expected ErrMissingMiddleware, got %v", err)
+	}
+
+	if err := ClearAll(c); err != ErrMissingMiddleware {
+		t.Errorf("Expected ErrMissingMiddleware, got %v", err)
+	}
+
+	nextCalled := false
+	c.Next = func() {
+		nextCalled = true
+	}
+
+	ContextIntentRouter().Middleware()(c)
+
+	if !nextCalled {
+		t.Error("Router should continue the chain when the context middleware is missing")
+	}
+
+	router := ContextIntentRouter()
+	noop := func(c *gbl.Context) {}
+
+	if err := CheckChain(noop, Middleware(), noop, router.Middleware()); err != nil {
+		t.Errorf("Expected a valid chain, got %v", err)
+	}
+
+	if err := CheckChain(noop, router.Middleware(), Middleware()); err != ErrMissingMiddleware {
+		t.Errorf("Expected ErrMissingMiddleware, got %v", err)
+	}
+}
+
+func TestRouteConditions(t *testing.T) {
+	handler := func(name string) gbl.MiddlewareFunction {
+		return func(c *gbl.Context) {
+			c.Flag("handler", name)
+		}
+	}
+
+	router := ContextIntentRouter()
+	router.Any(I{"book"}, C{"booking"}, handler("any"))
+	router.Route(I{"book"}, 10, handler("large-party"), HasAll(C{"booking"}), DataEquals("booking", "size", "large"))
+	router.Route(I{"book"}, 5, handler("confident-date"), HasEntity("date"), MinScore(0.8))
+	router.Route(I{"book"}, 5, handler("tomorrow"), EntityEquals("date", "tomorrow"))
+
+	route := func(setup func(c *gbl.Context)) string {
+		c := newTestContext("")
+		c.Flag("intent", "book")
+
+		run(c, func(c *gbl.Context) {
+			AddSourced(c, "booking", 5, "test")
+			setup(c)
+			router.Middleware()(c)
+		})
+
+		if !c.HasFlag("handler") {
+			return ""
+		}
+
+		return c.GetStringFlag("handler")
+	}
+
+	if h := route(func(c *gbl.Context) {}); h != "any" {
+		t.Errorf("Expected the any route, got %s", h)
+	}
+
+	if h := route(func(c *gbl.Context) { Set(c, "booking", "size", "large") }); h != "large-party" {
+		t.Errorf("Expected the higher priority route, got %s", h)
+	}
+
+	if h := route(func(c *gbl.Context) {
+		c.Flag("luis:e:date", []string{"tomorrow"})
+		c.Flag("intent:score", 0.9)
+	}); h != "confident-date" {
+		t.Errorf("Expected the first route of equal priority, got %s", h)
+	}
+
+	if h := route(func(c *gbl.Context) {
+		c.Flag("dflow:p:date", "tomorrow")
+		c.Flag("intent:score", 0.5)
+	}); h != "tomorrow" {
+		t.Errorf("Expected the entity value route, got %s", h)
+	}
+
+	// Routes without conditions match on the intent alone, Any and All without contexts never match
+	router = ContextIntentRouter()
+	router.Any(I{"book"}, nil, handler("any"))
+	router.Route(I{"book"}, 5, handler("intent-only"))
+
+	if h := route(func(c *gbl.Context) {}); h != "intent-only" {
+		t.Errorf("Expected the route without conditions, got %s", h)
+	}
+
+	router = ContextIntentRouter()
+	router.Any(I{"book"}, nil, handler("any"))
+	router.FallbackRoute(0, handler("catch-all"))
+
+	if h := route(func(c *gbl.Context) {}); h != "catch-all" {
+		t.Errorf("Expected the fallback without conditions, got %s", h)
+	}
+}
+
+func TestRouteTrace(t *testing.T) {
+	noop := func(c *gbl.Context) {}
+
+	router := ContextIntentRouter()
+	router.All(I{"book"}, C{"booking", "payment"}, noop)
+	router.FallbackAny(C{"booking"}, noop)
+	router.NoContext(I{"book"}, noop)
+	router.Debug(true)
+
+	routes := router.Routes()
+	if len(routes) != 3 || routes[0].Kind != "intent" || routes[1].Kind != "fallback" || routes[2].Kind != "no-context" {
+		t.Errorf("Routes are not listed in evaluation order %v", routes)
+	}
+
+	c := newTestContext("")
+	c.Flag("intent", "book")
+
+	run(c, func(c *gbl.Context) {
+		AddSourced(c, "booking", 5, "test")
+		router.Middleware()(c)
+	})
+
+	trace, ok := GetTrace(c)
+	if !ok {
+		t.Fatal("Expected a trace on the context")
+	}
+
+	if len(trace.Evaluated) != 2 || trace.Evaluated[0].Matched || trace.Evaluated[0].Reason == "" {
+		t.Errorf("Expected the all route to be skipped with a reason %+v", trace.Evaluated)
+	}
+
+	if trace.Chosen == nil || trace.Chosen.Kind != "fallback" || trace.Contexts[0] != "booking" {
+		t.Errorf("Expected the fallback to be chosen %+v", trace)
+	}
+}
+
+func TestForm(t *testing.T) {
+	prompt := func(slot string) gbl.MiddlewareFunction {
+		return func(c *gbl.Context) {
+			c.Flag("prompt", slot)
+		}
+	}
+
+	var completed map[string]Value
+
+	form := &Form{
+		Name:           "booking",
+		CancelIntents:  I{"cancel"},
+		RestartIntents: I{"restart"},
+		OnComplete: func(c *gbl.Context, values map[string]Value) {
+			completed = values
+		},
+		Slots: []Slot{
+			{Name: "name", Prompt: prompt("name"), FromText: true},
+			{Name: "date", Prompt: prompt("date"), Entities: []string{"datetime"}},
+			{Name: "size", Prompt: prompt("size"), Reprompt: prompt("size-again"), FromText: true, Validate: func(c *gbl.Context, input string) (interface{}, error) {
+				return strconv.Atoi(input)
+			}},
+		},
+	}
+
+	var c *gbl.Context
+	session := ""
+
+	send := func(text string, flags map[string]interface{}, handler gbl.MiddlewareFunction) string {
+		c = newTestContext(session)
+		c.Request.Text = text
+
+		for k, v := range flags {
+			c.Flag(k, v)
+		}
+
+		run(c, handler)
+		session = c.GetStringFlag("sess:_bctx")
+
+		if !c.HasFlag("prompt") {
+			return ""
+		}
+
+		return c.GetStringFlag("prompt")
+	}
+
+	if p := send("book a table", nil, func(c *gbl.Context) { form.Start(c) }); p != "name" {
+		t.Errorf("Expected to be asked for name, got %s", p)
+	}
+
+	// The date is filled from the entity at the same time as the name
+	if p := send("Sam", map[string]interface{}{"luis:e:datetime": []string{"tomorrow"}}, form.Middleware()); p != "size" {
+		t.Errorf("Expected to be asked for size, got %s", p)
+	}
+
+	if p := send("lots", nil, form.Middleware()); p != "size-again" || FormError(c) == "" {
+		t.Errorf("Expected to be reprompted for size, got %s %s", p, FormError(c))
+	}
+
+	send("4", nil, form.Middleware())
+
+	if completed == nil || completed["name"].String() != "Sam" || completed["date"].String() != "tomorrow" {
+		t.Fatalf("Expected the form to complete %v", completed)
+	}
+
+	if size, err := completed["size"].Int(); err != nil || size != 4 {
+		t.Errorf("Expected the validated size, got %d %v", size, err)
+	}
+
+	send("book again", nil, func(c *gbl.Context) { form.Start(c) })
+	send("", map[string]interface{}{"intent": "cancel"}, form.Middleware())
+
+	send("", nil, func(c *gbl.Context) {
+		if form.Active(c) {
+			t.Error("Form should not be active after being cancelled")
+		}
+	})
+}
+
+func TestDialogStack(t *testing.T) {
+	handler := func(name string) gbl.MiddlewareFunction {
+		return func(c *gbl.Context) {
+			c.Flag("handler", name)
+		}
+	}
+
+	router := ContextIntentRouter()
+	router.Dialog(I{"yes"}, "hours", handler("hours-yes"))
+	router.Any(I{"yes"}, C{"booking"}, handler("booking-yes"))
+
+	c := newTestContext("")
+	c.Flag("intent", "yes")
+
+	run(c, func(c *gbl.Context) {
+		AddSourced(c, "booking", 5, "test")
+		Set(c, "booking", "name", "Sam")
+
+		if err := Push(c, "hours"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	c = newTestContext(c.GetStringFlag("sess:_bctx"))
+	c.Flag("intent", "yes")
+
+	run(c, func(c *gbl.Context) {
+		if CurrentDialog(c) != "hours" {
+			t.Errorf("Expected the hours dialog, got %s", CurrentDialog(c))
+		}
+
+		router.Middleware()(c)
+
+		if c.GetStringFlag("handler") != "hours-yes" {
+			t.Errorf("Expected to route to the top of the stack, got %s", c.GetStringFlag("handler"))
+		}
+
+		if dialog, err := Pop(c); err != nil || dialog != "hours" {
+			t.Errorf("Expected to pop the hours dialog, got %s %v", dialog, err)
+		}
+
+		if Get(c, "booking", "name") != "Sam" {
+			t.Error("Expected the booking context to be restored")
+		}
+
+		router.Middleware()(c)
+
+		if c.GetStringFlag("handler") != "booking-yes" {
+			t.Errorf("Expected to route to the restored dialog, got %s", c.GetStringFlag("handler"))
+		}
+
+		if _, err := Pop(c); err != ErrEmptyDialogStack {
+			t.Errorf("Expected ErrEmptyDialogStack, got %v", err)
+		}
+	})
+}
+
+func TestDialogIdleTimeout(t *testing.T) {
+	current := time.Unix(1500000000, 0)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	expired := []string{}
+
+	hooks := Hooks()
+	hooks.OnExpire("booking", func(c *gbl.Context, ctx BCContext) {
+		expired = append(expired, ctx.Name)
+	})
+
+	c := newTestContext("")
+	middleware := MiddlewareWithConfig(&MiddlewareConfig{IdleTimeout: time.Hour, Hooks: hooks, HistorySize: 10})
+
+	handle := func(handler gbl.MiddlewareFunction) {
+		c.Next = func() {
+			c.Next = func() {}
+			handler(c)
+		}
+
+		middleware(c)
+	}
+
+	handle(func(c *gbl.Context) {
+		AddSourced(c, "booking", -1, "test")
+		Push(c, "hours")
+	})
+
+	current = current.Add(2 * time.Hour)
+
+	handle(func(c *gbl.Context) {
+		if dialog := CurrentDialog(c); dialog != "" {
+			t.Errorf("Expected idling out to end the dialog, got %s", dialog)
+		}
+
+		if _, err := Pop(c); err != ErrEmptyDialogStack {
+			t.Errorf("Expected an empty dialog stack, got %v", err)
+		}
+
+		botContext, _ := Current(c)
+		if _, exists := botContext.Contexts["booking"]; exists || len(expired) != 1 {
+			t.Errorf("Expected the suspended booking context to expire, got %v", expired)
+		}
+
+		history, _ := History(c)
+		if last := history[len(history)-1]; last.Event != HistoryExpired || last.Context != "booking" {
+			t.Errorf("Expected the expiry to be recorded, got %+v", last)
+		}
+	})
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	events := []string{}
+
+	hooks := Hooks()
+	hooks.OnEnter("booking", func(c *gbl.Context, ctx BCContext) {
+		events = append(events, "enter:"+ctx.Source)
+	})
+	hooks.OnChange("booking", func(c *gbl.Context, ctx BCContext, dataParam string, previous Value, exists bool) {
+		events = append(events, fmt.Sprintf("change:%s:%s:%v:%s", dataParam, previous.String(), exists, ctx.Data[dataParam].String()))
+	})
+	hooks.OnExpire("booking", func(c *gbl.Context, ctx BCContext) {
+		events = append(events, "expire:"+ctx.Data["name"].String())
+	})
+
+	c := newTestContext("")
+	middleware := MiddlewareWithConfig(&MiddlewareConfig{Hooks: hooks})
+
+	handle := func(handler gbl.MiddlewareFunction) {
+		c.Next = func() {
+			c.Next = func() {}
+			handler(c)
+		}
+
+		middleware(c)
+	}
+
+	handle(func(c *gbl.Context) {
+		AddSourced(c, "booking", 2, "test")
+		AddSourced(c, "menu", 2, "test")
+		Set(c, "booking", "name", "Sam")
+		Set(c, "booking", "name", "Sam")
+		Set(c, "booking", "name", "Alex")
+		Set(c, "menu", "name", "Sam")
+	})
+
+	// The booking context expires on the third request after it was added
+	for i := 0; i < 3; i++ {
+		handle(func(c *gbl.Context) {})
+	}
+
+	expected := []string{
+		"enter:test",
+		"change:name::false:Sam",
+		"change:name:Sam:true:Alex",
+		"expire:Alex",
+	}
+
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected hook events %v, got %v", expected, events)
+	}
+}
+
+func TestDialogflowBridge(t *testing.T) {
+	c := newTestContext("")
+
+	run(c, func(c *gbl.Context) {
+		AddSourced(c, "booking", 3, "test")
+		SetJSON(c, "booking", "guests", 2)
+		AddSourced(c, "menu", -1, "test")
+		AddSourced(c, "form:booking", 3, "test")
+		AddSourced(c, "stale", 2, "test")
+		AddSourced(c, "bookingFlow", 2, "test")
+
+		c.Next = func() {}
+		ExportDialogflow()(c)
+
+		expected := []DialogflowContext{
+			{Name: "booking", LifespanCount: 3, Parameters: map[string]interface{}{"guests": float64(2)}},
+			{Name: "bookingflow", LifespanCount: 2, Parameters: map[string]interface{}{}},
+			{Name: "menu", LifespanCount: DialogflowLifespan, Parameters: map[string]interface{}{}},
+			{Name: "stale", LifespanCount: 2, Parameters: map[string]interface{}{}},
+		}
+
+		if exported := c.GetFlag("dflow:contexts"); !reflect.DeepEqual(exported, expected) {
+			t.Errorf("Expected exported contexts %+v, got %+v", expected, exported)
+		}
+
+		// The output contexts as the dialogflow middleware would flag them
+		type outputContext struct {
+			Name          string                 `json:"name"`
+			LifespanCount int                    `json:"lifespanCount,omitempty"`
+			Parameters    map[string]interface{} `json:"parameters,omitempty"`
+		}
+
+		c.Flag("dflow:outputContexts", []outputContext{
+			{Name: "booking", LifespanCount: 5, Parameters: map[string]interface{}{"date": "tomorrow"}},
+			{Name: "menu", LifespanCount: 49},
+			{Name: "bookingflow", LifespanCount: 4, Parameters: map[string]interface{}{"step": "date"}},
+			{Name: "stale"},
+			{Name: "greeting-followup", LifespanCount: 2, Parameters: map[string]interface{}{"name": "Sam"}},
+			{Name: "__system_counters__", LifespanCount: 1},
+		})
+
+		ImportDialogflow()(c)
+
+		botContext, _ := Current(c)
+
+		if botContext.Contexts["booking"].CurrentLifetime != 5 || Get(c, "booking", "date") != "tomorrow" || Get(c, "booking", "guests") != "2" {
+			t.Errorf("Expected booking to be updated and merged, got %+v", botContext.Contexts["booking"])
+		}
+
+		if _, exists := botContext.Contexts["bookingflow"]; exists || botContext.Contexts["bookingFlow"].CurrentLifetime != 4 || Get(c, "bookingFlow", "step") != "date" {
+			t.Errorf("Expected bookingFlow to be matched ignoring case, got %v", botContext.names())
+		}
+
+		if botContext.Contexts["menu"].CurrentLifetime != -1 {
+			t.Error("Expected menu to keep living forever")
+		}
+
+		if _, exists := botContext.Contexts["stale"]; exists {
+			t.Error("Expected stale to be cleared")
+		}
+
+		if followup := botContext.Contexts["greeting-followup"]; followup.Source != "dialogflow" || Get(c, "greeting-followup", "name") != "Sam" {
+			t.Errorf("Expected greeting-followup to be added, got %+v", followup)
+		}
+
+		if _, exists := botContext.Contexts["__system_counters__"]; exists {
+			t.Error("Expected dialogflow system contexts to be ignored")
+		}
+	})
+}
+
+func TestMachine(t *testing.T) {
+	definition := `
+initial: idle
+states:
+  - name: idle
+    transitions:
+      - intents: [book]
+        to: asking_date
+        handler: askDate
+  - name: asking_date
+    fallback: repromptDate
+    transitions:
+      - intents: [date]
+        to: idle
+        handler: confirm
+        add:
+          - name: booked
+            lifetime: 5
+          - name: confirmed
+  - name: orphan
+    transitions:
+      - intents: [help]
+        to: nowhere
+        handler: missing
+`
+
+	path := filepath.Join(t.TempDir(), "machine.yaml")
+	if err := ioutil.WriteFile(path, []byte(definition), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err := LoadMachine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := func(name string) gbl.MiddlewareFunction {
+		return func(c *gbl.Context) {
+			c.Flag("handler", name)
+		}
+	}
+
+	handlers := map[string]gbl.MiddlewareFunction{
+		"askDate":      handler("askDate"),
+		"repromptDate": handler("repromptDate"),
+		"confirm":      handler("confirm"),
+	}
+
+	_, err = machine.Router(handlers)
+
+	machineErr, ok := err.(*MachineError)
+	if !ok {
+		t.Fatalf("Expected a *MachineError, got %v", err)
+	}
+
+	expected := []string{
+		"transition 0 of state orphan goes to the undefined state nowhere",
+		"transition 0 of state orphan uses the missing handler missing",
+		"state orphan is unreachable",
+	}
+
+	if !reflect.DeepEqual(machineErr.Problems, expected) {
+		t.Errorf("Expected problems %v, got %v", expected, machineErr.Problems)
+	}
+
+	machine.States = machine.States[:2]
+
+	router, err := machine.Router(handlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := ""
+
+	send := func(intent string) *gbl.Context {
+		c := newTestContext(session)
+		c.Flag("intent", intent)
+
+		run(c, router.Middleware())
+
+		session = c.GetStringFlag("sess:_bctx")
+		return c
+	}
+
+	steps := []struct {
+		intent  string
+		handler string
+		state   string
+	}{
+		{"date", "", "idle"},
+		{"book", "askDate", "asking_date"},
+		{"book", "repromptDate", "asking_date"},
+		{"date", "confirm", "idle"},
+	}
+
+	for _, step := range steps {
+		c := send(step.intent)
+
+		handled := ""
+		if c.HasFlag("handler") {
+			handled = c.GetStringFlag("handler")
+		}
+
+		if handled != step.handler {
+			t.Errorf("Expected %s to be handled by %s, got %s", step.intent, step.handler, handled)
+		}
+
+		if state := machine.State(c); state != step.state {
+			t.Errorf("Expected %s to move to state %s, got %s", step.intent, step.state, state)
+		}
+	}
+
+	decoded, _ := decodeContext(session)
+
+	if decoded.Contexts["booked"].Source != "machine" || decoded.Contexts["booked"].CurrentLifetime != 5 {
+		t.Error("Expected the confirm transition to add the booked context")
+	}
+
+	if decoded.Contexts["confirmed"].CurrentLifetime != -1 {
+		t.Errorf("Expected a context added without a lifetime to be kept, got %+v", decoded.Contexts["confirmed"])
+	}
+}
+
+func TestCodecsAndLimits(t *testing.T) {
+	for _, codec := range []Codec{nil, JSONCodec, MsgpackCodec} {
+		for _, compress := range []bool{false, true} {
+			middleware := MiddlewareWithConfig(&MiddlewareConfig{
+				Codec:       codec,
+				Compress:    compress,
+				MaxContexts: 2,
+			})
+
+			c := newTestContext("")
+			c.Next = func() {
+				c.Next = func() {}
+
+				AddSourced(c, "first", 5, "test")
+				AddSourced(c, "second", -1, "test")
+				SetJSON(c, "second", "guests", []int{1, 2})
+				Push(c, "hours")
+				AddSourced(c, "third", 5, "test")
+				AddSourced(c, "fourth", 5, "test")
+				AddSourced(c, "fifth", 5, "test")
+			}
+
+			middleware(c)
+
+			encoded := c.GetFlag("sess:_bctx")
+
+			decoded, err := decodeContext(encoded)
+			if err != nil {
+				t.Fatalf("codec=%v compress=%v %v", codec, compress, err)
+			}
+
+			if envelope, isBytes := encoded.([]byte); codec != nil && (!isBytes || !bytes.HasPrefix(envelope, envelopeMagic)) {
+				t.Errorf("Expected a %s envelope, got %v", codec.Name(), encoded)
+			}
+
+			if _, isString := encoded.(string); codec == nil && !isString {
+				t.Errorf("Expected plain JSON without a codec, got %v", encoded)
+			}
+
+			if len(decoded.Contexts) != 2 {
+				t.Errorf("Expected the contexts to be limited to 2, got %v", decoded.Contexts)
+			}
+
+			suspended := decoded.Dialogs[0].Suspended["second"]
+			if guests := suspended.Data["guests"].String(); guests != "[1,2]" || suspended.CurrentLifetime != -1 {
+				t.Errorf("Expected suspended contexts to survive encoding, got %+v", suspended)
+			}
+		}
+	}
+
+	c := newTestContext("")
+	c.Next = func() {
+		c.Next = func() {}
+
+		AddSourced(c, "small", 5, "test")
+		AddSourced(c, "large", 5, "test")
+		Set(c, "large", "text", strings.Repeat("a", 500))
+	}
+
+	MiddlewareWithConfig(&MiddlewareConfig{MaxSize: 300, Evict: EvictLargest})(c)
+
+	decoded, _ := decodeContext(c.GetFlag("sess:_bctx"))
+	if _, exists := decoded.Contexts["large"]; exists || len(decoded.Contexts) != 1 {
+		t.Errorf("Expected the largest context to be evicted, got %v", decoded.Contexts)
+	}
+
+	c = newTestContext("")
+	c.Next = func() {
+		c.Next = func() {}
+
+		for i := 0; i < 6; i++ {
+			name := "context" + strconv.Itoa(i)
+
+			AddSourced(c, name, 5, "test")
+			Set(c, name, "text", "abc")
+		}
+	}
+
+	MiddlewareWithConfig(&MiddlewareConfig{MaxSize: 600, HistorySize: 20})(c)
+
+	encoded := c.GetStringFlag("sess:_bctx")
+	decoded, _ = decodeContext(encoded)
+
+	if len(encoded) > 600 {
+		t.Errorf("Expected the context to be at most 600 bytes, got %d", len(encoded))
+	}
+
+	if len(decoded.Contexts) != 6 || len(decoded.History) >= 12 {
+		t.Errorf("Expected the history to be trimmed before evicting, got %d contexts and %d history entries", len(decoded.Contexts), len(decoded.History))
+	}
+}
+
+func TestMsgpackSize(t *testing.T) {
+	ctx := BotContext{Contexts: make(map[string]BCContext)}
+
+	for i := 0; i < 5; i++ {
+		name := "context" + strconv.Itoa(i)
+		count, _ := NewValue(i * 1000)
+		ok, _ := NewValue(true)
+
+		ctx.Contexts[name] = BCContext{
+			Name:            name,
+			Source:          "test",
+			CurrentLifetime: 5,
+			Data: map[string]Value{
+				"count": count,
+				"name":  StringValue("guest " + strconv.Itoa(i)),
+				"ok":    ok,
+			},
+		}
+	}
+
+	jsonEncoded, err := encodeContext(&ctx, JSONCodec, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgpackEncoded, err := encodeContext(&ctx, MsgpackCodec, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if encodedSize(msgpackEncoded) >= encodedSize(jsonEncoded) {
+		t.Errorf("Expected msgpack (%d bytes) to be smaller than JSON (%d bytes)", encodedSize(msgpackEncoded), encodedSize(jsonEncoded))
+	}
+
+	decoded, err := decodeContext(msgpackEncoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := decoded.Contexts["context3"].Data["count"].String(); count != "3000" {
+		t.Errorf("Expected values to survive msgpack, got %s", count)
+	}
+}
+
+type testSessionStore map[string]map[string]interface{}
+
+func (store testSessionStore) Get(id string) (map[string]interface{}, error) {
+	session, exists := store[id]
+	if !exists {
+		return nil, fmt.Errorf("session %s does not exist", id)
+	}
+
+	return session, nil
+}
+
+func TestHistory(t *testing.T) {
+	c := newTestContext("")
+	middleware := MiddlewareWithConfig(&MiddlewareConfig{HistorySize: 5})
+
+	handle := func(handler gbl.MiddlewareFunction) {
+		c.Next = func() {
+			c.Next = func() {}
+			handler(c)
+		}
+
+		middleware(c)
+	}
+
+	handle(func(c *gbl.Context) {
+		AddSourced(c, "menu", 1, "greeting")
+		AddSourced(c, "booking", -1, "menu")
+		Set(c, "booking", "name", "Sam")
+		Set(c, "booking", "name", "Sam")
+	})
+
+	handle(func(c *gbl.Context) {})
+
+	handle(func(c *gbl.Context) {
+		Clear(c, "booking")
+	})
+
+	history, err := History(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []HistoryEntry{
+		{Event: HistoryAdded, Sequence: 1, Context: "booking", Source: "menu"},
+		{Event: HistoryChanged, Sequence: 1, Context: "booking", Source: "menu", Param: "name"},
+		{Event: HistoryExpired, Sequence: 3, Context: "menu", Source: "greeting"},
+		{Event: HistoryRemoved, Sequence: 3, Context: "booking", Source: "menu"},
+	}
+
+	for i := range history {
+		history[i].Time = 0
+	}
+
+	// The first entry is dropped once the history is full
+	if !reflect.DeepEqual(history[1:], expected) || len(history) != 5 {
+		t.Errorf("Expected history %+v, got %+v", expected, history)
+	}
+
+	store := testSessionStore{"123": {"_bctx": c.GetStringFlag("sess:_bctx")}}
+	handler := HistoryHandler(store)
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/?id=123", nil))
+
+	var botContext BotContext
+	if err := json.NewDecoder(res.Body).Decode(&botContext); err != nil || len(botContext.History) != 5 {
+		t.Errorf("Expected the handler to return the history, got %d %v", res.Code, err)
+	}
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/?id=456", nil))
+
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown user, got %d", res.Code)
+	}
+}
@@ -1,6 +1,8 @@
 package bctx
 
 import (
+	"fmt"
+
 	"github.com/calebhiebert/gobbl"
 )
 
@@ -9,15 +11,19 @@ import (
 type RContextIntentRouter struct {
 	handlers  map[string][]ContextRouterQuery
 	fallbacks []ContextRouterQuery
+	debug     bool
 }
 
 // ContextRouterQuery represents a set of parameters used to decide
 // to fire this handler or not
 type ContextRouterQuery struct {
-	intentOnly bool
+	intent     string
+	intentOnly bool // set by Route and FallbackRoute, the query matches when it has no conditions
 	noContext  bool
 	any        C
 	all        C
+	conditions []Condition
+	priority   int
 	handler    gbl.MiddlewareFunction
 }
 
@@ -46,9 +52,35 @@ func (cr *RContextIntentRouter) NoContext(intents I, handler gbl.MiddlewareFunct
 	}
 }
 
+// Route will match if the intent matches and every condition passes, a route without conditions
+// matches on the intent alone. Routes with a higher
+// priority are checked first, routes registered with Any, All and NoContext have a priority of 0
+func (cr *RContextIntentRouter) Route(intents I, priority int, handler gbl.MiddlewareFunction, conditions ...Condition) {
+	for _, intent := range intents {
+		addQueryForIntent(cr, intent, &ContextRouterQuery{
+			intentOnly: true,
+			conditions: conditions,
+			priority:   priority,
+			handler:    handler,
+		})
+	}
+}
+
+// FallbackRoute will match if every condition passes, regardless of intent. A fallback without
+// conditions matches every request that no intent route matched. Fallbacks
+// with a higher priority are checked first
+func (cr *RContextIntentRouter) FallbackRoute(priority int, handler gbl.MiddlewareFunction, conditions ...Condition) {
+	cr.fallbacks = insertByPriority(cr.fallbacks, ContextRouterQuery{
+		intentOnly: true,
+		conditions: conditions,
+		priority:   priority,
+		handler:    handler,
+	})
+}
+
 // FallbackAny will match if the user has any of the provided contexts, regardless of intent
 func (cr *RContextIntentRouter) FallbackAny(contexts C, handler gbl.MiddlewareFunction) {
-	cr.fallbacks = append(cr.fallbacks, ContextRouterQuery{
+	cr.fallbacks = insertByPriority(cr.fallbacks, ContextRouterQuery{
 		handler: handler,
 		any:     contexts,
 	})
@@ -56,12 +88,18 @@ func (cr *RContextIntentRouter) FallbackAny(contexts C, handler gbl.MiddlewareFu
 
 // FallbackAll will match if the user has all of the provided contexts, regardless of intent
 func (cr *RContextIntentRouter) FallbackAll(contexts C, handler gbl.MiddlewareFunction) {
-	cr.fallbacks = append(cr.fallbacks, ContextRouterQuery{
+	cr.fallbacks = insertByPriority(cr.fallbacks, ContextRouterQuery{
 		handler: handler,
 		all:     contexts,
 	})
 }
 
+// Dialog will match if the intent matches and the dialog is on top of the dialog stack.
+// Dialog routes have a priority of 1 so they are checked before routes that ignore the dialog
+func (cr *RContextIntentRouter) Dialog(intents I, dialog string, handler gbl.MiddlewareFunction) {
+	cr.Route(intents, 1, handler, InDialog(dialog))
+}
+
 // Any will match if the intent matches, and any of the supplied contexts are present
 func (cr *RContextIntentRouter) Any(intents I, contexts C, handler gbl.MiddlewareFunction) {
 	for _, intent := range intents {
@@ -83,11 +121,60 @@ func (cr *RContextIntentRouter) All(intents I, contexts C, handler gbl.Middlewar
 }
 
 func addQueryForIntent(cr *RContextIntentRouter, intent string, query *ContextRouterQuery) {
-	if queries, exists := cr.handlers[intent]; !exists {
-		cr.handlers[intent] = []ContextRouterQuery{*query}
-	} else {
-		cr.handlers[intent] = append(queries, *query)
+	query.intent = intent
+	cr.handlers[intent] = insertByPriority(cr.handlers[intent], *query)
+}
+
+// insertByPriority adds the query after every query with the same or a higher priority
+func insertByPriority(queries []ContextRouterQuery, query ContextRouterQuery) []ContextRouterQuery {
+	idx := len(queries)
+
+	for i, existing := range queries {
+		if existing.priority < query.priority {
+			idx = i
+			break
+		}
+	}
+
+	queries = append(queries, ContextRouterQuery{})
+	copy(queries[idx+1:], queries[idx:])
+	queries[idx] = query
+
+	return queries
+}
+
+// matches returns true if the query matches the current request. No context
+// queries never match here, they are checked separately
+func (query ContextRouterQuery) matches(c *gbl.Context, botContext *BotContext) bool {
+	matched, _ := query.check(c, botContext)
+	return matched
+}
+
+// check returns true if the query matches the current request, or the reason it did not
+func (query ContextRouterQuery) check(c *gbl.Context, botContext *BotContext) (bool, string) {
+	if query.noContext {
+		return false, "only matches without contexts"
+	}
+
+	if query.all != nil && !hasAllContexts(botContext, query.all) {
+		return false, fmt.Sprintf("missing one of the contexts %v", query.all)
+	}
+
+	if query.any != nil && !hasAnyContexts(botContext, query.any) {
+		return false, fmt.Sprintf("has none of the contexts %v", query.any)
+	}
+
+	if query.all == nil && query.any == nil && query.conditions == nil && !query.intentOnly {
+		return false, "has nothing to match on"
+	}
+
+	for i, condition := range query.conditions {
+		if !condition(c, botContext) {
+			return false, fmt.Sprintf("condition %d failed", i)
+		}
 	}
+
+	return true, ""
 }
 
 func hasAllContexts(botContext *BotContext, contexts []string) bool {
@@ -110,60 +197,90 @@ func hasAnyContexts(botContext *BotContext, contexts []string) bool {
 	return false
 }
 
-// Middleware generates the middleware required to use this router
+// Debug will make the router record every evaluated route, the active contexts and the chosen
+// route on each request. The trace is logged and stored on the context (see GetTrace)
+func (cr *RContextIntentRouter) Debug(enabled bool) {
+	cr.debug = enabled
+}
+
+// Middleware generates the middleware required to use this router.
+// The context middleware must run before this one, use CheckChain at startup to make sure it does
 func (cr *RContextIntentRouter) Middleware() gbl.MiddlewareFunction {
 	return func(c *gbl.Context) {
+		botContext, err := Current(c)
+		if err != nil {
+			c.Errorf("Context router skipped %v", err)
+			c.Next()
+			return
+		}
+
+		var trace *RouteTrace
+
+		if cr.debug {
+			trace = newTrace(c, botContext)
+		}
 
-		// Only run checks if the context object exists on the request
-		if c.HasFlag("_bctxDecoded") {
-			botContext := c.GetFlag("_bctxDecoded").(*BotContext)
-
-			// First check if an intent is present
-			if c.HasFlag("intent") {
-				intent := c.GetStringFlag("intent")
-
-				// If an intent is present, check to see if any handlers match
-				if intentCollection, exists := cr.handlers[intent]; exists {
-					for _, query := range intentCollection {
-						if query.all != nil && hasAllContexts(botContext, query.all) {
-							query.handler(c)
-							return
-						} else if query.any != nil && hasAnyContexts(botContext, query.any) {
-							query.handler(c)
-							return
-						}
-					}
-				}
-			}
-
-			// Process fallbacks, only after intents have been checked
-			for _, fallback := range cr.fallbacks {
-				if fallback.all != nil && hasAllContexts(botContext, fallback.all) {
-					fallback.handler(c)
-					return
-				} else if fallback.any != nil && hasAnyContexts(botContext, fallback.any) {
-					fallback.handler(c)
-					return
-				}
-			}
-
-			// Check no context intents, only after in-context intents, and fallbacks have been processed
-			if c.HasFlag("intent") {
-				intent := c.GetStringFlag("intent")
-
-				if intentCollection, exists := cr.handlers[intent]; exists && len(botContext.Contexts) == 0 {
-					for _, query := range intentCollection {
-						if query.noContext {
-							query.handler(c)
-							return
-						}
-					}
-				}
-			}
-		} else {
-			panic("Missing _bctxDecoded!")
+		query := cr.route(c, botContext, trace)
+
+		if trace != nil {
+			trace.log(c)
+			c.Flag(traceFlagName, trace)
+		}
+
+		if query != nil {
+			query.handler(c)
+			return
 		}
 
 		c.Next()
 	}
 }
+
+// route returns the first query that matches the request, or nil if none match
+func (cr *RContextIntentRouter) route(c *gbl.Context, botContext *BotContext, trace *RouteTrace) *ContextRouterQuery {
+	// Intent handlers are only checked if an intent is present
+	intentQueries := []ContextRouterQuery{}
+	if c.HasFlag("intent") {
+		intentQueries = cr.handlers[c.GetStringFlag("intent")]
+	}
+
+	// First check if any handlers match the intent
+	for _, query := range intentQueries {
+		if query.noContext {
+			continue
+		}
+
+		matched, reason := query.check(c, botContext)
+		trace.evaluated(query, matched, reason)
+
+		if matched {
+			return &query
+		}
+	}
+
+	// Process fallbacks, only after intents have been checked
+	for _, fallback := range cr.fallbacks {
+		matched, reason := fallback.check(c, botContext)
+		trace.evaluated(fallback, matched, reason)
+
+		if matched {
+			return &fallback
+		}
+	}
+
+	// Check no context intents, only after in-context intents, and fallbacks have been processed
+	for _, query := range intentQueries {
+		if !query.noContext {
+			continue
+		}
+
+		matched := len(botContext.Contexts) == 0
+		trace.evaluated(query, matched, "user has contexts")
+
+		if matched {
+			return &query
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package bctx
+
+import (
+	"errors"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// ErrEmptyDialogStack is returned when popping a dialog while no dialog has been pushed
+var ErrEmptyDialogStack = errors.New("dialog stack is empty")
+
+// ErrDialogStackFull is returned when pushing more than MaxDialogDepth dialogs
+var ErrDialogStackFull = errors.New("dialog stack is full")
+
+// MaxDialogDepth is the maximum number of dialogs that can be on the stack
+var MaxDialogDepth = 16
+
+// Dialog is a single entry on the dialog stack
+type Dialog struct {
+	Name string `json:"n"`
+
+	// Suspended holds the contexts that were active when this dialog was pushed,
+	// they are restored when it is popped
+	Suspended map[string]BCContext `json:"c"`
+}
+
+// Push will start a sub dialog. The current contexts are put aside and the sub dialog
+// starts without any, until it is popped
+func Push(c *gbl.Context, dialog string) error {
+	botContext, err := Current(c)
+	if err != nil {
+		return err
+	}
+
+	if len(botContext.Dialogs) >= MaxDialogDepth {
+		return ErrDialogStackFull
+	}
+
+	botContext.Dialogs = append(botContext.Dialogs, Dialog{
+		Name:      dialog,
+		Suspended: botContext.Contexts,
+	})
+
+	botContext.Contexts = make(map[string]BCContext)
+	botContext.record(HistoryPushed, dialog, "", "")
+
+	return nil
+}
+
+// Pop will end the current dialog and restore the contexts of the previous one.
+// The name of the dialog that ended is returned
+func Pop(c *gbl.Context) (string, error) {
+	botContext, err := Current(c)
+	if err != nil {
+		return "", err
+	}
+
+	if len(botContext.Dialogs) == 0 {
+		return "", ErrEmptyDialogStack
+	}
+
+	top := botContext.Dialogs[len(botContext.Dialogs)-1]
+	botContext.Dialogs = botContext.Dialogs[:len(botContext.Dialogs)-1]
+
+	botContext.Contexts = top.Suspended
+	if botContext.Contexts == nil {
+		botContext.Contexts = make(map[string]BCContext)
+	}
+
+	botContext.record(HistoryPopped, top.Name, "", "")
+
+	return top.Name, nil
+}
+
+// CurrentDialog returns the name of the dialog on top of the stack,
+// or a blank string if no dialog has been pushed
+func CurrentDialog(c *gbl.Context) string {
+	botContext, err := Current(c)
+	if err != nil {
+		return ""
+	}
+
+	return botContext.currentDialog()
+}
+
+// InDialog passes if the dialog is on top of the stack, use a blank
+// name to match when no dialog has been pushed
+func InDialog(dialog string) Condition {
+	return func(c *gbl.Context, botContext *BotContext) bool {
+		return botContext.currentDialog() == dialog
+	}
+}
+
+// currentDialog returns the name of the dialog on top of the stack
+func (botContext *BotContext) currentDialog() string {
+	if len(botContext.Dialogs) == 0 {
+		return ""
+	}
+
+	return botContext.Dialogs[len(botContext.Dialogs)-1].Name
+}
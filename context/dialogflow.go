@@ -0,0 +1,206 @@
+package bctx
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// dialogflowContextsFlagName is read by the dialogflow middleware and sent with the query
+var dialogflowContextsFlagName = "dflow:contexts"
+
+// dialogflowOutputFlagName is set by the dialogflow middleware with the response's output contexts
+var dialogflowOutputFlagName = "dflow:outputContexts"
+
+// dialogflowSource is the source of contexts added by dialogflow
+var dialogflowSource = "dialogflow"
+
+// DialogflowLifespan is the lifespan sent to dialogflow for contexts that
+// do not expire by turns, dialogflow has no contexts that live forever
+var DialogflowLifespan = 50
+
+// dialogflowName matches the context names dialogflow accepts
+var dialogflowName = regexp.MustCompile(`^[a-zA-Z0-9_\-%]+$`)
+
+// DialogflowContext has the same JSON encoding as a dialogflow context
+type DialogflowContext struct {
+	Name          string                 `json:"name"`
+	LifespanCount int                    `json:"lifespanCount,omitempty"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+}
+
+/*
+ExportDialogflow returns a middleware that sends the active contexts along with the
+dialogflow query, and ImportDialogflow returns one that merges the contexts dialogflow
+outputs back into the bot context. The dialogflow middleware goes between them
+
+	bot.Use(bctx.Middleware())
+	bot.Use(bctx.ExportDialogflow())
+	bot.Use(gobbldflow.Middleware(dflow))
+	bot.Use(bctx.ImportDialogflow())
+	bot.Use(router.Middleware())
+*/
+func ExportDialogflow() gbl.MiddlewareFunction {
+	return func(c *gbl.Context) {
+		contexts, err := DialogflowContexts(c)
+		if err != nil {
+			c.Errorf("Dialogflow export skipped %v", err)
+			c.Next()
+			return
+		}
+
+		c.Flag(dialogflowContextsFlagName, contexts)
+		c.Next()
+	}
+}
+
+// ImportDialogflow returns a middleware that merges the contexts dialogflow output
+// into the bot context, see ExportDialogflow
+func ImportDialogflow() gbl.MiddlewareFunction {
+	return func(c *gbl.Context) {
+		if !c.HasFlag(dialogflowOutputFlagName) {
+			c.Next()
+			return
+		}
+
+		var contexts []DialogflowContext
+
+		jsonBytes, err := json.Marshal(c.GetFlag(dialogflowOutputFlagName))
+		if err == nil {
+			err = json.Unmarshal(jsonBytes, &contexts)
+		}
+
+		if err == nil {
+			err = MergeDialogflowContexts(c, contexts)
+		}
+
+		if err != nil {
+			c.Errorf("Dialogflow import skipped %v", err)
+		}
+
+		c.Next()
+	}
+}
+
+// DialogflowContexts returns the active contexts in the form dialogflow expects. Names are
+// lowercased like dialogflow does, contexts with names dialogflow does not accept are left out
+func DialogflowContexts(c *gbl.Context) ([]DialogflowContext, error) {
+	botContext, err := Current(c)
+	if err != nil {
+		return nil, err
+	}
+
+	contexts := []DialogflowContext{}
+	exported := map[string]bool{}
+
+	for _, name := range botContext.names() {
+		ctx := botContext.Contexts[name]
+
+		if !dialogflowName.MatchString(name) {
+			c.Tracef("Context %s was not sent to dialogflow, the name is not valid there", name)
+			continue
+		}
+
+		if exported[strings.ToLower(name)] {
+			c.Tracef("Context %s was not sent to dialogflow, another context has the same lowercase name", name)
+			continue
+		}
+
+		exported[strings.ToLower(name)] = true
+
+		lifespan := ctx.CurrentLifetime
+		if lifespan == -1 {
+			lifespan = DialogflowLifespan
+		}
+
+		parameters := make(map[string]interface{})
+
+		for param, value := range ctx.Data {
+			var decoded interface{}
+
+			if err := value.Decode(&decoded); err == nil {
+				parameters[param] = decoded
+			}
+		}
+
+		contexts = append(contexts, DialogflowContext{
+			Name:          strings.ToLower(name),
+			LifespanCount: lifespan,
+			Parameters:    parameters,
+		})
+	}
+
+	return contexts, nil
+}
+
+// MergeDialogflowContexts will merge contexts output by dialogflow into the bot context.
+// Contexts with a lifespan of 0 are cleared, new contexts are added with the source
+// "dialogflow" and existing ones have their lifetime updated and parameters merged.
+// Names are matched ignoring case, dialogflow returns every name lowercased.
+// Contexts that do not expire by turns keep doing so
+func MergeDialogflowContexts(c *gbl.Context, contexts []DialogflowContext) error {
+	botContext, err := Current(c)
+	if err != nil {
+		return err
+	}
+
+	for _, dfContext := range contexts {
+		name := dfContext.Name[strings.LastIndex(dfContext.Name, "/")+1:]
+
+		// Dialogflow keeps internal state in contexts like __system_counters__
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+
+		name = botContext.matchName(name)
+		ctx, exists := botContext.Contexts[name]
+
+		switch {
+		case dfContext.LifespanCount <= 0:
+			if exists {
+				botContext.record(HistoryRemoved, name, dialogflowSource, "")
+				delete(botContext.Contexts, name)
+			}
+
+			continue
+		case !exists:
+			err = AddSourced(c, name, dfContext.LifespanCount, dialogflowSource)
+			if err != nil {
+				return err
+			}
+		case ctx.CurrentLifetime != -1:
+			ctx.CurrentLifetime = dfContext.LifespanCount
+			ctx.BirthSequence = botContext.Sequence
+			botContext.Contexts[name] = ctx
+		}
+
+		for param, v := range dfContext.Parameters {
+			value, err := NewValue(v)
+			if err != nil {
+				return err
+			}
+
+			SetValue(c, name, param, value)
+		}
+	}
+
+	return nil
+}
+
+// matchName returns the name of the active context that matches the name ignoring case,
+// or the name itself if none match
+func (botContext *BotContext) matchName(name string) string {
+	if _, exists := botContext.Contexts[name]; exists {
+		return name
+	}
+
+	for _, existing := range botContext.names() {
+		if strings.EqualFold(existing, name) {
+			return existing
+		}
+	}
+
+	return name
+}
@@ -0,0 +1,269 @@
+package bctx
+
+import (
+	"strings"
+	"time"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+var formErrorFlagName = "_bctxFormError"
+
+// askingParam is the data param of the form context that stores the slot being asked for
+var askingParam = "_asking"
+
+// Form collects a set of slots from the user, asking for each one that is missing.
+// The form's state is kept in a context named "form:" + Name
+type Form struct {
+	Name  string
+	Slots []Slot
+
+	// CancelIntents stop the form, OnCancel is called if it is set
+	CancelIntents I
+	OnCancel      gbl.MiddlewareFunction
+
+	// RestartIntents clear every filled slot and ask for the first one again
+	RestartIntents I
+
+	// OnComplete is called with the value of every slot once they are all filled
+	OnComplete func(c *gbl.Context, values map[string]Value)
+
+	// TTL optionally expires an abandoned form, see AddTimed
+	TTL time.Duration
+}
+
+// Slot is a single value collected by a form
+type Slot struct {
+	Name string
+
+	// Prompt is called to ask the user for the slot
+	Prompt gbl.MiddlewareFunction
+
+	// Reprompt is called when the user's answer was invalid, the reason is available
+	// from FormError. If Reprompt is nil, Prompt is used
+	Reprompt gbl.MiddlewareFunction
+
+	// Entities are NLU entities (luis:e:* or dflow:p:*) that fill the slot. Entities
+	// can fill any missing slot, so users can answer several questions at once
+	Entities []string
+
+	// FromText fills the slot with the raw request text when it is being asked for
+	// and none of its entities were found
+	FromText bool
+
+	// Validate checks and converts the input, the returned value is stored in the slot.
+	// If Validate is nil, any non blank input is stored as a string
+	Validate func(c *gbl.Context, input string) (interface{}, error)
+}
+
+// ContextName returns the name of the context that holds the form's state
+func (f *Form) ContextName() string {
+	return "form:" + f.Name
+}
+
+// Active returns true if the form is currently collecting slots
+func (f *Form) Active(c *gbl.Context) bool {
+	botContext, err := Current(c)
+	if err != nil {
+		return false
+	}
+
+	_, exists := botContext.Contexts[f.ContextName()]
+
+	return exists
+}
+
+// Start will begin the form, any slots already present in the NLU entities are filled
+// and the user is asked for the first missing slot
+func (f *Form) Start(c *gbl.Context) error {
+	err := AddTimed(c, f.ContextName(), -1, f.TTL, "form")
+	if err != nil {
+		return err
+	}
+
+	f.fill(c)
+	f.advance(c)
+
+	return nil
+}
+
+// Values returns the slots that have been filled so far
+func (f *Form) Values(c *gbl.Context) map[string]Value {
+	values := make(map[string]Value)
+
+	for _, slot := range f.Slots {
+		if value, ok := Lookup(c, f.ContextName(), slot.Name); ok {
+			values[slot.Name] = value
+		}
+	}
+
+	return values
+}
+
+// FormError returns the reason the user's last answer was rejected, blank if it was not
+func FormError(c *gbl.Context) string {
+	if !c.HasFlag(formErrorFlagName) {
+		return ""
+	}
+
+	return c.GetStringFlag(formErrorFlagName)
+}
+
+// Middleware handles every request while the form is active,
+// requests continue down the chain while it is not
+func (f *Form) Middleware() gbl.MiddlewareFunction {
+	return func(c *gbl.Context) {
+		if !f.Active(c) {
+			c.Next()
+			return
+		}
+
+		intent := ""
+		if c.HasFlag("intent") {
+			intent = c.GetStringFlag("intent")
+		}
+
+		if contains(f.CancelIntents, intent) {
+			Clear(c, f.ContextName())
+
+			if f.OnCancel != nil {
+				f.OnCancel(c)
+			}
+
+			return
+		}
+
+		if contains(f.RestartIntents, intent) {
+			Clear(c, f.ContextName())
+			f.Start(c)
+			return
+		}
+
+		asking := Get(c, f.ContextName(), askingParam)
+
+		if !f.fill(c) {
+			// The answer to the slot being asked for was invalid
+			f.ask(c, f.slot(asking), true)
+			return
+		}
+
+		f.advance(c)
+	}
+}
+
+// fill will fill missing slots from the request, returns false
+// if the slot being asked for was answered with an invalid value
+func (f *Form) fill(c *gbl.Context) bool {
+	asking := Get(c, f.ContextName(), askingParam)
+	valid := true
+
+	for _, slot := range f.Slots {
+		if _, filled := Lookup(c, f.ContextName(), slot.Name); filled {
+			continue
+		}
+
+		var input string
+
+		for _, entity := range slot.Entities {
+			if values := entityValues(c, entity); len(values) > 0 {
+				input = values[0]
+				break
+			}
+		}
+
+		if input == "" && slot.FromText && slot.Name == asking {
+			input = strings.TrimSpace(c.Request.Text)
+		}
+
+		if input == "" {
+			continue
+		}
+
+		value, err := slot.validate(c, input)
+		if err != nil {
+			if slot.Name == asking {
+				c.Flag(formErrorFlagName, err.Error())
+				valid = false
+			}
+
+			continue
+		}
+
+		SetValue(c, f.ContextName(), slot.Name, value)
+	}
+
+	return valid
+}
+
+// advance asks for the next missing slot, or completes the form
+func (f *Form) advance(c *gbl.Context) {
+	for _, slot := range f.Slots {
+		if _, filled := Lookup(c, f.ContextName(), slot.Name); !filled {
+			f.ask(c, &slot, false)
+			return
+		}
+	}
+
+	values := f.Values(c)
+
+	Clear(c, f.ContextName())
+
+	if f.OnComplete != nil {
+		f.OnComplete(c, values)
+	}
+}
+
+// ask prompts the user for the slot
+func (f *Form) ask(c *gbl.Context, slot *Slot, invalid bool) {
+	if slot == nil {
+		f.advance(c)
+		return
+	}
+
+	Set(c, f.ContextName(), askingParam, slot.Name)
+
+	prompt := slot.Prompt
+	if invalid && slot.Reprompt != nil {
+		prompt = slot.Reprompt
+	}
+
+	if prompt != nil {
+		prompt(c)
+	}
+}
+
+// slot returns the slot with the given name, or nil
+func (f *Form) slot(name string) *Slot {
+	for i := range f.Slots {
+		if f.Slots[i].Name == name {
+			return &f.Slots[i]
+		}
+	}
+
+	return nil
+}
+
+// validate checks and converts the input for the slot
+func (s Slot) validate(c *gbl.Context, input string) (Value, error) {
+	if s.Validate == nil {
+		return StringValue(input), nil
+	}
+
+	converted, err := s.Validate(c, input)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return NewValue(converted)
+}
+
+// contains returns true if the list contains the string
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
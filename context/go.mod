@@ -1,5 +1,17 @@
 module github.com/calebhiebert/gobbl-extra/context
 
-go 1.12
+go 1.18
 
-require github.com/calebhiebert/gobbl v0.0.5
+require (
+	github.com/calebhiebert/gobbl v0.0.5
+	github.com/vmihailenco/msgpack v4.0.4+incompatible
+	gopkg.in/yaml.v2 v2.2.2
+)
+
+require (
+	github.com/golang/protobuf v1.3.1 // indirect
+	github.com/logrusorgru/aurora v0.0.0-20190428105938-cea283e61946 // indirect
+	github.com/matoous/go-nanoid v0.0.0-20190515092250-e998f83de84d // indirect
+	golang.org/x/net v0.0.0-20190603091049-60506f45cf65 // indirect
+	google.golang.org/appengine v1.6.1 // indirect
+)
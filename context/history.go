@@ -0,0 +1,144 @@
+package bctx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// HistoryEvent is the kind of change recorded in the history
+type HistoryEvent string
+
+const (
+	// HistoryAdded is recorded when a context is added
+	HistoryAdded HistoryEvent = "added"
+
+	// HistoryRemoved is recorded when a context is cleared
+	HistoryRemoved HistoryEvent = "removed"
+
+	// HistoryExpired is recorded when a context runs out of lifetime, time or is idled out
+	HistoryExpired HistoryEvent = "expired"
+
+	// HistoryEvicted is recorded when a context is removed to meet the size limits
+	HistoryEvicted HistoryEvent = "evicted"
+
+	// HistoryChanged is recorded when a data param of a context changes
+	HistoryChanged HistoryEvent = "changed"
+
+	// HistoryPushed and HistoryPopped are recorded when a dialog is pushed or popped,
+	// the context of the entry is the name of the dialog
+	HistoryPushed HistoryEvent = "pushed"
+	HistoryPopped HistoryEvent = "popped"
+)
+
+// sessionKey is the session key the encoded bot context is stored under
+var sessionKey = "_bctx"
+
+// HistoryEntry is a single change to the contexts of a user
+type HistoryEntry struct {
+	Event    HistoryEvent `json:"e"`
+	Sequence int          `json:"s"`
+	Time     int64        `json:"t"`
+	Context  string       `json:"n"`
+	Source   string       `json:"src,omitempty"`
+
+	// Param is the data param that changed, it is only set for HistoryChanged
+	Param string `json:"p,omitempty"`
+}
+
+// SessionGetter is the part of a session store used to look up a user's contexts
+type SessionGetter interface {
+	Get(id string) (map[string]interface{}, error)
+}
+
+// History returns the history of the user on the context, oldest first. History is
+// only recorded when MiddlewareConfig.HistorySize is set
+func History(c *gbl.Context) ([]HistoryEntry, error) {
+	botContext, err := Current(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]HistoryEntry{}, botContext.History...), nil
+}
+
+// SessionContext decodes the bot context stored in a user's session data
+func SessionContext(session map[string]interface{}) (*BotContext, error) {
+	encoded, ok := session[sessionKey]
+	if !ok {
+		return nil, errors.New("session has no contexts")
+	}
+
+	botContext, err := decodeContext(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &botContext, nil
+}
+
+/*
+HistoryHandler returns an http handler that can be used by admin tooling to look up
+the contexts, dialogs and history of a user. The handler does no authentication,
+so it should be mounted behind some
+
+	GET /?id={id}    returns the bot context of the user as JSON
+*/
+func HistoryHandler(store SessionGetter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeError(w, http.StatusBadRequest, errors.New("missing id"))
+			return
+		}
+
+		session, err := store.Get(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		botContext, err := SessionContext(session)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(botContext)
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// record adds an entry to the history, dropping the oldest entries once it is full
+func (botContext *BotContext) record(event HistoryEvent, name, source, param string) {
+	if botContext.historySize <= 0 {
+		return
+	}
+
+	botContext.History = append(botContext.History, HistoryEntry{
+		Event:    event,
+		Sequence: botContext.Sequence,
+		Time:     now().Unix(),
+		Context:  name,
+		Source:   source,
+		Param:    param,
+	})
+
+	if overflow := len(botContext.History) - botContext.historySize; overflow > 0 {
+		botContext.History = append([]HistoryEntry{}, botContext.History[overflow:]...)
+	}
+}
@@ -0,0 +1,76 @@
+package bctx
+
+import (
+	"github.com/calebhiebert/gobbl"
+)
+
+// Hook is called with the context that was added or expired
+type Hook func(c *gbl.Context, ctx BCContext)
+
+// ChangeHook is called after a data param of the context was changed to a different value, previous
+// is the value it had before and exists is false if it was not set
+type ChangeHook func(c *gbl.Context, ctx BCContext, dataParam string, previous Value, exists bool)
+
+// LifecycleHooks holds the hooks registered for each context name,
+// hooks are run within the request that causes the transition
+type LifecycleHooks struct {
+	enter  map[string][]Hook
+	change map[string][]ChangeHook
+	expire map[string][]Hook
+}
+
+// Hooks creates an empty set of hooks, pass it to the middleware with MiddlewareConfig
+func Hooks() *LifecycleHooks {
+	return &LifecycleHooks{
+		enter:  make(map[string][]Hook),
+		change: make(map[string][]ChangeHook),
+		expire: make(map[string][]Hook),
+	}
+}
+
+// OnEnter registers a hook that is called every time the context is added
+func (h *LifecycleHooks) OnEnter(contextName string, hook Hook) {
+	h.enter[contextName] = append(h.enter[contextName], hook)
+}
+
+// OnChange registers a hook that is called every time a data param of the context changes
+func (h *LifecycleHooks) OnChange(contextName string, hook ChangeHook) {
+	h.change[contextName] = append(h.change[contextName], hook)
+}
+
+// OnExpire registers a hook that is called when the context runs out of lifetime,
+// passes its expiry time or is dropped by the idle timeout. Contexts removed with
+// Clear or ClearAll do not expire
+func (h *LifecycleHooks) OnExpire(contextName string, hook Hook) {
+	h.expire[contextName] = append(h.expire[contextName], hook)
+}
+
+func (h *LifecycleHooks) entered(c *gbl.Context, ctx BCContext) {
+	if h == nil {
+		return
+	}
+
+	for _, hook := range h.enter[ctx.Name] {
+		hook(c, ctx)
+	}
+}
+
+func (h *LifecycleHooks) changed(c *gbl.Context, ctx BCContext, dataParam string, previous Value, exists bool) {
+	if h == nil {
+		return
+	}
+
+	for _, hook := range h.change[ctx.Name] {
+		hook(c, ctx, dataParam, previous, exists)
+	}
+}
+
+func (h *LifecycleHooks) expired(c *gbl.Context, ctx BCContext) {
+	if h == nil {
+		return
+	}
+
+	for _, hook := range h.expire[ctx.Name] {
+		hook(c, ctx)
+	}
+}
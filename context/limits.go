@@ -0,0 +1,91 @@
+package bctx
+
+import (
+	"github.com/calebhiebert/gobbl"
+)
+
+// EvictionPolicy picks the name of the context to remove when the bot context is over
+// its limits, a blank name stops the eviction
+type EvictionPolicy func(botContext *BotContext) string
+
+// EvictOldest removes the context that was added first
+func EvictOldest(botContext *BotContext) string {
+	evict := ""
+	oldest := 0
+
+	for _, name := range botContext.names() {
+		if birth := botContext.Contexts[name].BirthSequence; evict == "" || birth < oldest {
+			evict = name
+			oldest = birth
+		}
+	}
+
+	return evict
+}
+
+// EvictLargest removes the context holding the most data
+func EvictLargest(botContext *BotContext) string {
+	evict := ""
+	largest := -1
+
+	for _, name := range botContext.names() {
+		size := 0
+		for param, value := range botContext.Contexts[name].Data {
+			size += len(param) + len(value.Raw())
+		}
+
+		if size > largest {
+			evict = name
+			largest = size
+		}
+	}
+
+	return evict
+}
+
+// encode will evict contexts until the bot context is within the limits of the config
+// and return it encoded. Over MaxSize the history is trimmed first, so eviction
+// records are only kept while they fit
+func (config *MiddlewareConfig) encode(c *gbl.Context, botContext *BotContext) (interface{}, error) {
+	evict := config.Evict
+	if evict == nil {
+		evict = EvictOldest
+	}
+
+	for config.MaxContexts > 0 && len(botContext.Contexts) > config.MaxContexts {
+		if !config.evictOne(c, botContext, evict) {
+			break
+		}
+	}
+
+	for {
+		encoded, err := encodeContext(botContext, config.Codec, config.Compress)
+		if err != nil || config.MaxSize <= 0 || encodedSize(encoded) <= config.MaxSize {
+			return encoded, err
+		}
+
+		if len(botContext.History) > 0 {
+			botContext.History = botContext.History[1:]
+			continue
+		}
+
+		if !config.evictOne(c, botContext, evict) {
+			c.Warnf("Encoded context is %d bytes, over the limit of %d", encodedSize(encoded), config.MaxSize)
+			return encoded, nil
+		}
+	}
+}
+
+// evictOne removes a single context, returns false if nothing was removed
+func (config *MiddlewareConfig) evictOne(c *gbl.Context, botContext *BotContext, evict EvictionPolicy) bool {
+	name := evict(botContext)
+	if _, exists := botContext.Contexts[name]; !exists {
+		return false
+	}
+
+	c.Warnf("Evicting context %s, the context is over its limits", name)
+	botContext.record(HistoryEvicted, name, botContext.Contexts[name].Source, "")
+	delete(botContext.Contexts, name)
+
+	return true
+}
@@ -0,0 +1,315 @@
+package bctx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/calebhiebert/gobbl"
+	"gopkg.in/yaml.v2"
+)
+
+// machineSource is the source of contexts added by a state machine
+var machineSource = "machine"
+
+/*
+Machine is a declarative dialog definition, it can be loaded from a JSON or YAML file.
+Every state except the initial one is a context with the state's name, the user is in
+the initial state while none of the state contexts are present
+
+	initial: idle
+	states:
+	  - name: idle
+	    transitions:
+	      - intents: [book]
+	        to: asking_date
+	        handler: askDate
+	  - name: asking_date
+	    lifetime: 3
+	    fallback: repromptDate
+	    transitions:
+	      - intents: [date]
+	        to: idle
+	        handler: confirmBooking
+	        add:
+	          - name: booked
+	            lifetime: 5
+	      - intents: [cancel]
+	        to: idle
+	        handler: cancelBooking
+*/
+type Machine struct {
+	Initial string  `json:"initial" yaml:"initial"`
+	States  []State `json:"states" yaml:"states"`
+}
+
+// State is a single state of a machine
+type State struct {
+	Name string `json:"name" yaml:"name"`
+
+	// Lifetime of the state's context, 0 keeps the user in the state until a transition
+	Lifetime int `json:"lifetime" yaml:"lifetime"`
+
+	// Fallback is the name of the handler called when no transition matches the intent
+	Fallback string `json:"fallback" yaml:"fallback"`
+
+	Transitions []Transition `json:"transitions" yaml:"transitions"`
+}
+
+// Transition moves the user to another state when one of its intents is received
+type Transition struct {
+	Intents []string `json:"intents" yaml:"intents"`
+
+	// To is the state the user moves to, blank to stay in the current state
+	To string `json:"to" yaml:"to"`
+
+	// Handler is the name of the handler called after the transition, it is optional
+	Handler string `json:"handler" yaml:"handler"`
+
+	// Add and Clear are contexts added or cleared as part of the transition
+	Add   []ContextSpec `json:"add" yaml:"add"`
+	Clear []string      `json:"clear" yaml:"clear"`
+}
+
+// ContextSpec is a context added by a transition
+type ContextSpec struct {
+	Name string `json:"name" yaml:"name"`
+
+	// Lifetime of the context, 0 keeps it until it is cleared, like a state's lifetime
+	Lifetime int `json:"lifetime" yaml:"lifetime"`
+}
+
+// MachineError lists every problem found when validating a machine
+type MachineError struct {
+	Problems []string
+}
+
+func (err *MachineError) Error() string {
+	return "invalid state machine: " + strings.Join(err.Problems, "; ")
+}
+
+// LoadMachine will read a JSON or YAML (.yaml or .yml) machine definition
+func LoadMachine(path string) (*Machine, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var machine Machine
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(file, &machine)
+	default:
+		err = json.Unmarshal(file, &machine)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &machine, nil
+}
+
+// Validate returns a *MachineError listing unknown or duplicate states, states that cannot
+// be reached from the initial state and handler names that are missing from handlers
+func (m *Machine) Validate(handlers map[string]gbl.MiddlewareFunction) error {
+	problems := []string{}
+	states := make(map[string]State)
+
+	for _, state := range m.States {
+		if state.Name == "" {
+			problems = append(problems, "a state has no name")
+			continue
+		}
+
+		if _, exists := states[state.Name]; exists {
+			problems = append(problems, fmt.Sprintf("state %s is defined more than once", state.Name))
+		}
+
+		states[state.Name] = state
+	}
+
+	if _, exists := states[m.initial()]; !exists {
+		problems = append(problems, fmt.Sprintf("initial state %s is not defined", m.initial()))
+	}
+
+	handlerExists := func(name string) bool {
+		_, exists := handlers[name]
+		return exists
+	}
+
+	for _, state := range m.States {
+		if state.Fallback != "" && !handlerExists(state.Fallback) {
+			problems = append(problems, fmt.Sprintf("state %s uses the missing fallback handler %s", state.Name, state.Fallback))
+		}
+
+		for i, transition := range state.Transitions {
+			if len(transition.Intents) == 0 {
+				problems = append(problems, fmt.Sprintf("transition %d of state %s has no intents", i, state.Name))
+			}
+
+			if _, exists := states[transition.To]; transition.To != "" && !exists {
+				problems = append(problems, fmt.Sprintf("transition %d of state %s goes to the undefined state %s", i, state.Name, transition.To))
+			}
+
+			if transition.Handler != "" && !handlerExists(transition.Handler) {
+				problems = append(problems, fmt.Sprintf("transition %d of state %s uses the missing handler %s", i, state.Name, transition.Handler))
+			}
+		}
+	}
+
+	reachable := m.reachable(states)
+
+	for _, state := range m.States {
+		if !reachable[state.Name] && state.Name != "" {
+			problems = append(problems, fmt.Sprintf("state %s is unreachable", state.Name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &MachineError{Problems: problems}
+	}
+
+	return nil
+}
+
+// Router validates the machine and creates a router that follows it
+func (m *Machine) Router(handlers map[string]gbl.MiddlewareFunction) (*RContextIntentRouter, error) {
+	err := m.Validate(handlers)
+	if err != nil {
+		return nil, err
+	}
+
+	router := ContextIntentRouter()
+	initial := m.initial()
+
+	for _, state := range m.States {
+		for _, transition := range state.Transitions {
+			handler := m.transition(state, transition, handlers[transition.Handler])
+
+			if state.Name == initial {
+				router.Route(transition.Intents, 0, handler, m.inInitial)
+			} else {
+				router.Any(transition.Intents, C{state.Name}, handler)
+			}
+		}
+
+		if state.Fallback == "" {
+			continue
+		}
+
+		if state.Name == initial {
+			router.FallbackRoute(0, handlers[state.Fallback], m.inInitial)
+		} else {
+			router.FallbackAny(C{state.Name}, handlers[state.Fallback])
+		}
+	}
+
+	return router, nil
+}
+
+// State returns the name of the state the user is in
+func (m *Machine) State(c *gbl.Context) string {
+	botContext, err := Current(c)
+	if err != nil {
+		return m.initial()
+	}
+
+	for _, state := range m.States {
+		if _, exists := botContext.Contexts[state.Name]; exists && state.Name != m.initial() {
+			return state.Name
+		}
+	}
+
+	return m.initial()
+}
+
+// initial returns the initial state, the first state is used if none is set
+func (m *Machine) initial() string {
+	if m.Initial == "" && len(m.States) > 0 {
+		return m.States[0].Name
+	}
+
+	return m.Initial
+}
+
+// inInitial is a condition that passes while the user is in none of the state contexts
+func (m *Machine) inInitial(c *gbl.Context, botContext *BotContext) bool {
+	for _, state := range m.States {
+		if _, exists := botContext.Contexts[state.Name]; exists && state.Name != m.initial() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// transition creates the handler that moves the user from the state and then calls handler
+func (m *Machine) transition(from State, transition Transition, handler gbl.MiddlewareFunction) gbl.MiddlewareFunction {
+	return func(c *gbl.Context) {
+		for _, name := range transition.Clear {
+			Clear(c, name)
+		}
+
+		for _, spec := range transition.Add {
+			lifetime := spec.Lifetime
+			if lifetime == 0 {
+				lifetime = -1
+			}
+
+			AddSourced(c, spec.Name, lifetime, machineSource)
+		}
+
+		if transition.To != "" {
+			Clear(c, from.Name)
+
+			if transition.To != m.initial() {
+				AddSourced(c, transition.To, m.lifetime(transition.To), machineSource)
+			}
+		}
+
+		if handler != nil {
+			handler(c)
+		}
+	}
+}
+
+// lifetime returns the lifetime of the state's context
+func (m *Machine) lifetime(stateName string) int {
+	for _, state := range m.States {
+		if state.Name == stateName && state.Lifetime != 0 {
+			return state.Lifetime
+		}
+	}
+
+	return -1
+}
+
+// reachable returns the states that can be reached from the initial state
+func (m *Machine) reachable(states map[string]State) map[string]bool {
+	reachable := map[string]bool{}
+	queue := []string{m.initial()}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		state, exists := states[name]
+		if !exists || reachable[name] {
+			continue
+		}
+
+		reachable[name] = true
+
+		for _, transition := range state.Transitions {
+			if transition.To != "" {
+				queue = append(queue, transition.To)
+			}
+		}
+	}
+
+	return reachable
+}
@@ -2,13 +2,51 @@ package bctx
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/calebhiebert/gobbl"
 )
 
+// MiddlewareConfig holds the optional settings of the context middleware
+type MiddlewareConfig struct {
+
+	// IdleTimeout clears every context when the user has not sent a request
+	// for longer than the timeout. If IdleTimeout is 0, contexts never expire by idling.
+	// Requests are timed in whole seconds, the timeout is rounded up to a whole second
+	IdleTimeout time.Duration
+
+	// Hooks are called when contexts are added, changed or expire
+	Hooks *LifecycleHooks
+
+	// Codec encodes the context stored on the session, if Codec is nil the context is
+	// stored as plain JSON. Contexts stored with any codec can always be read
+	Codec Codec
+
+	// Compress gzips the encoded context, it is ignored if Codec is nil
+	Compress bool
+
+	// MaxContexts and MaxSize (in bytes of the encoded context) limit the size of the
+	// stored context, 0 means no limit. Contexts are removed with Evict until the limits
+	// are met, if Evict is nil the oldest contexts are removed first. When over MaxSize the
+	// oldest history entries are dropped before any context is evicted
+	MaxContexts int
+	MaxSize     int
+	Evict       EvictionPolicy
+
+	// HistorySize is the number of changes to the contexts kept in the history (see History),
+	// if HistorySize is 0 no history is kept
+	HistorySize int
+}
+
 // Middleware will generate the context middleware, this will take care
 // of setting and managing user sessions
 func Middleware() gbl.MiddlewareFunction {
+	return MiddlewareWithConfig(&MiddlewareConfig{})
+}
+
+// MiddlewareWithConfig will generate the context middleware using the given config
+func MiddlewareWithConfig(config *MiddlewareConfig) gbl.MiddlewareFunction {
 	return func(c *gbl.Context) {
 
 		// Check to see if the context is set in the session
@@ -20,7 +58,7 @@ func Middleware() gbl.MiddlewareFunction {
 			}
 
 			// Encode our new blank context
-			encodedContext, err := encodeContext(&blankContext)
+			encodedContext, err := encodeContext(&blankContext, config.Codec, config.Compress)
 			if err != nil {
 				c.Error(fmt.Sprintf("Context Error %v", err))
 			}
@@ -30,7 +68,7 @@ func Middleware() gbl.MiddlewareFunction {
 		}
 
 		// Decode the context from the session
-		decodedContext, err := decodeContext(c.GetStringFlag("sess:_bctx"))
+		decodedContext, err := decodeContext(c.GetFlag("sess:_bctx"))
 		if err != nil {
 			c.Error(fmt.Sprintf("Context Decode Error %v", err))
 			c.Next()
@@ -39,8 +77,17 @@ func Middleware() gbl.MiddlewareFunction {
 
 		decodedContext.Sequence++
 
+		currentTime := now().Unix()
+
+		// Drop every context if the user has been idle for too long
+		idle := config.IdleTimeout > 0 && decodedContext.LastSeen != 0 &&
+			currentTime-decodedContext.LastSeen > int64((config.IdleTimeout+time.Second-1)/time.Second)
+
+		decodedContext.LastSeen = currentTime
+
 		// Create a new slice to store all the contexts that are still alive
 		liveContexts := map[string]BCContext{}
+		expiredContexts := []BCContext{}
 
 		// Increment context current life time and track living ones
 		for name, contextEntry := range decodedContext.Contexts {
@@ -49,15 +96,47 @@ func Middleware() gbl.MiddlewareFunction {
 				contextEntry.CurrentLifetime--
 			}
 
-			if contextEntry.CurrentLifetime > 0 || contextEntry.CurrentLifetime == -1 {
+			expired := idle || (contextEntry.ExpiresAt != 0 && currentTime >= contextEntry.ExpiresAt)
+
+			if !expired && (contextEntry.CurrentLifetime > 0 || contextEntry.CurrentLifetime == -1) {
 				liveContexts[name] = contextEntry
+			} else {
+				expiredContexts = append(expiredContexts, contextEntry)
+			}
+		}
+
+		// Idling out also ends every dialog, the contexts they suspended expire with the rest
+		if idle {
+			for _, dialog := range decodedContext.Dialogs {
+				for _, suspended := range dialog.Suspended {
+					expiredContexts = append(expiredContexts, suspended)
+				}
 			}
+
+			decodedContext.Dialogs = nil
 		}
 
 		decodedContext.Contexts = liveContexts
+		decodedContext.hooks = config.Hooks
+		decodedContext.historySize = config.HistorySize
+
+		// Drop any history over the size, the size may have been lowered
+		if overflow := len(decodedContext.History) - config.HistorySize; overflow > 0 {
+			decodedContext.History = decodedContext.History[overflow:]
+		}
 
 		c.Flag(flagKeyName, &decodedContext)
 
+		// Run the expiry hooks in a stable order
+		sort.Slice(expiredContexts, func(i, j int) bool {
+			return expiredContexts[i].Name < expiredContexts[j].Name
+		})
+
+		for _, expired := range expiredContexts {
+			decodedContext.record(HistoryExpired, expired.Name, expired.Source, "")
+			config.Hooks.expired(c, expired)
+		}
+
 		// Complete the bot runthrough
 		c.Next()
 
@@ -69,7 +148,7 @@ func Middleware() gbl.MiddlewareFunction {
 
 		updatedContext := c.GetFlag(flagKeyName).(*BotContext)
 
-		encodedUpdatedContext, err := encodeContext(updatedContext)
+		encodedUpdatedContext, err := config.encode(c, updatedContext)
 		if err != nil {
 			c.Error(fmt.Sprintf("Error encoding updated context %v", err))
 		}
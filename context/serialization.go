@@ -1,23 +1,216 @@
 package bctx
 
-import "encoding/json"
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
 
-func encodeContext(ctx *BotContext) (string, error) {
-	jsonBytes, err := json.Marshal(ctx)
+	"github.com/vmihailenco/msgpack"
+)
+
+// envelopeMagic starts every encoded context that is wrapped in an envelope. Envelopes are
+// stored as []byte, contexts stored as a string are plain JSON, written without a codec
+var envelopeMagic = []byte("bctx")
+
+// envelopeVersion is the version of the envelope format, envelopes look like
+// <magic> <version byte> <compression byte> <codec name length byte> <codec name> <payload>
+const envelopeVersion = 1
+
+// compression marks how the payload of an envelope is compressed
+const (
+	compressionNone = 0
+	compressionGzip = 1
+)
+
+// Codec encodes the bot context that is stored on the session
+type Codec interface {
+	// Name identifies the codec in the envelope, it must be shorter than 256 bytes
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := msgpack.NewEncoder(&buf).UseJSONTag(true).Encode(v)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return string(jsonBytes), nil
+	return buf.Bytes(), nil
 }
 
-func decodeContext(jsonString string) (BotContext, error) {
-	var bctx BotContext
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.NewDecoder(bytes.NewReader(data)).UseJSONTag(true).Decode(v)
+}
+
+// JSONCodec encodes contexts as JSON
+var JSONCodec Codec = jsonCodec{}
+
+// MsgpackCodec encodes contexts as msgpack, like the redis session store
+var MsgpackCodec Codec = msgpackCodec{}
+
+var codecs = map[string]Codec{
+	JSONCodec.Name():    JSONCodec,
+	MsgpackCodec.Name(): MsgpackCodec,
+}
 
-	err := json.Unmarshal([]byte(jsonString), &bctx)
+var codecsMutex = &sync.RWMutex{}
+
+// RegisterCodec makes a custom codec available for decoding, the json and
+// msgpack codecs are always available
+func RegisterCodec(codec Codec) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+
+	codecs[codec.Name()] = codec
+}
+
+func getCodec(name string) (Codec, bool) {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+
+	codec, exists := codecs[name]
+	return codec, exists
+}
+
+// encodeContext encodes the context with the codec. The context is stored as a plain
+// JSON string if codec is nil, otherwise as an envelope in a []byte
+func encodeContext(ctx *BotContext, codec Codec, compress bool) (interface{}, error) {
+	if codec == nil {
+		jsonBytes, err := json.Marshal(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return string(jsonBytes), nil
+	}
+
+	payload, err := codec.Marshal(ctx)
 	if err != nil {
+		return nil, err
+	}
+
+	if len(codec.Name()) > 255 {
+		return nil, fmt.Errorf("codec name %s is too long", codec.Name())
+	}
+
+	var buf bytes.Buffer
+
+	buf.Write(envelopeMagic)
+	buf.WriteByte(envelopeVersion)
+
+	if compress {
+		buf.WriteByte(compressionGzip)
+	} else {
+		buf.WriteByte(compressionNone)
+	}
+
+	buf.WriteByte(byte(len(codec.Name())))
+	buf.WriteString(codec.Name())
+
+	if !compress {
+		buf.Write(payload)
+		return buf.Bytes(), nil
+	}
+
+	writer := gzip.NewWriter(&buf)
+
+	_, err = writer.Write(payload)
+	if err == nil {
+		err = writer.Close()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodedSize returns the size in bytes of an encoded context
+func encodedSize(encoded interface{}) int {
+	switch v := encoded.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+
+	return 0
+}
+
+// decodeContext decodes a context written by encodeContext with any codec. Stores may
+// return envelopes as a string, so strings that start with the envelope magic are envelopes
+func decodeContext(encoded interface{}) (BotContext, error) {
+	var bctx BotContext
+
+	var data []byte
+
+	switch v := encoded.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return bctx, fmt.Errorf("cannot decode a context from %T", encoded)
+	}
+
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		err := json.Unmarshal(data, &bctx)
 		return bctx, err
 	}
 
-	return bctx, nil
+	data = data[len(envelopeMagic):]
+
+	if len(data) < 3 || len(data) < 3+int(data[2]) {
+		return bctx, fmt.Errorf("malformed context envelope")
+	}
+
+	if data[0] != envelopeVersion {
+		return bctx, fmt.Errorf("unsupported context envelope version %d", data[0])
+	}
+
+	compression := data[1]
+	name := string(data[3 : 3+int(data[2])])
+	payload := data[3+int(data[2]):]
+
+	codec, exists := getCodec(name)
+	if !exists {
+		return bctx, fmt.Errorf("unknown context codec %s", name)
+	}
+
+	switch compression {
+	case compressionNone:
+	case compressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return bctx, err
+		}
+
+		payload, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return bctx, err
+		}
+	default:
+		return bctx, fmt.Errorf("unknown context compression %d", compression)
+	}
+
+	err := codec.Unmarshal(payload, &bctx)
+
+	return bctx, err
 }
@@ -0,0 +1,202 @@
+package bctx
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+var traceFlagName = "_bctxTrace"
+
+// RouteInfo describes a single route of a context intent router
+type RouteInfo struct {
+
+	// Kind is either intent, fallback or no-context
+	Kind string `json:"kind"`
+
+	// Intent is blank for fallbacks
+	Intent     string `json:"intent,omitempty"`
+	Priority   int    `json:"priority"`
+	Any        C      `json:"any,omitempty"`
+	All        C      `json:"all,omitempty"`
+	Conditions int    `json:"conditions"`
+	Handler    string `json:"handler"`
+}
+
+// RouteEvaluation is the result of checking a single route
+type RouteEvaluation struct {
+	Route   RouteInfo `json:"route"`
+	Matched bool      `json:"matched"`
+
+	// Reason explains why the route did not match
+	Reason string `json:"reason,omitempty"`
+}
+
+// RouteTrace records how a router handled a single request
+type RouteTrace struct {
+	Intent    string            `json:"intent"`
+	Dialog    string            `json:"dialog"`
+	Contexts  []string          `json:"contexts"`
+	Evaluated []RouteEvaluation `json:"evaluated"`
+
+	// Chosen is nil if no route matched and the request continued down the chain
+	Chosen *RouteInfo `json:"chosen"`
+}
+
+// String returns a readable description of the route
+func (r RouteInfo) String() string {
+	description := r.Kind
+
+	if r.Intent != "" {
+		description += " " + r.Intent
+	}
+
+	if r.All != nil {
+		description += fmt.Sprintf(" all=%v", r.All)
+	}
+
+	if r.Any != nil {
+		description += fmt.Sprintf(" any=%v", r.Any)
+	}
+
+	if r.Conditions > 0 {
+		description += fmt.Sprintf(" conditions=%d", r.Conditions)
+	}
+
+	return fmt.Sprintf("%s priority=%d -> %s", description, r.Priority, r.Handler)
+}
+
+// Routes lists every route in the order they are checked, intents are
+// listed alphabetically. This is useful to dump the routing table at startup
+func (cr *RContextIntentRouter) Routes() []RouteInfo {
+	intents := []string{}
+
+	for intent := range cr.handlers {
+		intents = append(intents, intent)
+	}
+
+	sort.Strings(intents)
+
+	routes := []RouteInfo{}
+	noContext := []RouteInfo{}
+
+	for _, intent := range intents {
+		for _, query := range cr.handlers[intent] {
+			if query.noContext {
+				noContext = append(noContext, query.info())
+			} else {
+				routes = append(routes, query.info())
+			}
+		}
+	}
+
+	for _, fallback := range cr.fallbacks {
+		routes = append(routes, fallback.info())
+	}
+
+	return append(routes, noContext...)
+}
+
+// GetTrace returns the trace recorded by a router in debug mode for the current request
+func GetTrace(c *gbl.Context) (*RouteTrace, bool) {
+	if !c.HasFlag(traceFlagName) {
+		return nil, false
+	}
+
+	trace, ok := c.GetFlag(traceFlagName).(*RouteTrace)
+
+	return trace, ok
+}
+
+// info returns the description of the query
+func (query ContextRouterQuery) info() RouteInfo {
+	kind := "intent"
+
+	switch {
+	case query.noContext:
+		kind = "no-context"
+	case query.intent == "":
+		kind = "fallback"
+	}
+
+	return RouteInfo{
+		Kind:       kind,
+		Intent:     query.intent,
+		Priority:   query.priority,
+		Any:        query.any,
+		All:        query.all,
+		Conditions: len(query.conditions),
+		Handler:    handlerName(query.handler),
+	}
+}
+
+// newTrace starts a trace for the current request
+func newTrace(c *gbl.Context, botContext *BotContext) *RouteTrace {
+	trace := &RouteTrace{
+		Dialog:    botContext.currentDialog(),
+		Contexts:  []string{},
+		Evaluated: []RouteEvaluation{},
+	}
+
+	if c.HasFlag("intent") {
+		trace.Intent = c.GetStringFlag("intent")
+	}
+
+	trace.Contexts = append(trace.Contexts, botContext.names()...)
+
+	return trace
+}
+
+// evaluated records the result of checking a query, it does nothing on a nil trace
+func (trace *RouteTrace) evaluated(query ContextRouterQuery, matched bool, reason string) {
+	if trace == nil {
+		return
+	}
+
+	info := query.info()
+
+	if matched {
+		reason = ""
+		trace.Chosen = &info
+	}
+
+	trace.Evaluated = append(trace.Evaluated, RouteEvaluation{
+		Route:   info,
+		Matched: matched,
+		Reason:  reason,
+	})
+}
+
+// log writes the trace to the trace log
+func (trace *RouteTrace) log(c *gbl.Context) {
+	c.Tracef("Context router intent=%s dialog=%s contexts=%v", trace.Intent, trace.Dialog, trace.Contexts)
+
+	for _, evaluation := range trace.Evaluated {
+		if evaluation.Matched {
+			c.Tracef("  MATCHED %s", evaluation.Route)
+		} else {
+			c.Tracef("  skipped %s (%s)", evaluation.Route, evaluation.Reason)
+		}
+	}
+
+	if trace.Chosen == nil {
+		c.Tracef("  no route matched")
+	}
+}
+
+// handlerName returns the name of the function behind a handler
+func handlerName(handler gbl.MiddlewareFunction) string {
+	if handler == nil {
+		return "nil"
+	}
+
+	fn := runtime.FuncForPC(reflect.ValueOf(handler).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+
+	return fn.Name()
+}
@@ -0,0 +1,160 @@
+package bctx
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// Value is a single context data param. It is stored as raw JSON, so strings
+// set by older versions of this package are read back as string values
+type Value struct {
+	raw json.RawMessage
+}
+
+// NewValue will create a value by JSON encoding v
+func NewValue(v interface{}) (Value, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{raw: raw}, nil
+}
+
+// StringValue will create a value holding a string
+func StringValue(s string) Value {
+	value, _ := NewValue(s)
+	return value
+}
+
+// MarshalJSON returns the raw JSON of the value
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v.raw == nil {
+		return []byte("null"), nil
+	}
+
+	return v.raw, nil
+}
+
+// UnmarshalJSON stores a copy of the raw JSON
+func (v *Value) UnmarshalJSON(data []byte) error {
+	v.raw = append(json.RawMessage{}, data...)
+	return nil
+}
+
+// EncodeMsgpack stores the value as its native msgpack type
+func (v Value) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if v.raw == nil {
+		return enc.EncodeNil()
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(v.raw))
+	decoder.UseNumber()
+
+	var decoded interface{}
+
+	err := decoder.Decode(&decoded)
+	if err != nil {
+		return err
+	}
+
+	return enc.Encode(nativeNumbers(decoded))
+}
+
+// DecodeMsgpack reads a value stored by EncodeMsgpack
+func (v *Value) DecodeMsgpack(dec *msgpack.Decoder) error {
+	decoded, err := dec.DecodeInterface()
+	if err != nil {
+		return err
+	}
+
+	value, err := NewValue(decoded)
+	if err != nil {
+		return err
+	}
+
+	*v = value
+	return nil
+}
+
+// nativeNumbers replaces the json.Numbers in a decoded JSON value with int64s
+// where possible and float64s otherwise, so they are stored in as few bytes as possible
+func nativeNumbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = nativeNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = nativeNumbers(item)
+		}
+	}
+
+	return v
+}
+
+// Raw returns the raw JSON of the value
+func (v Value) Raw() json.RawMessage {
+	return v.raw
+}
+
+// String returns the value as a string. Strings are returned as is,
+// any other type of value is returned as its JSON
+func (v Value) String() string {
+	var s string
+
+	if err := json.Unmarshal(v.raw, &s); err == nil {
+		return s
+	}
+
+	return string(v.raw)
+}
+
+// Int returns the value as an int, numbers stored as strings are parsed
+func (v Value) Int() (int, error) {
+	var i int
+
+	if err := json.Unmarshal(v.raw, &i); err == nil {
+		return i, nil
+	}
+
+	return strconv.Atoi(v.String())
+}
+
+// Float returns the value as a float64, numbers stored as strings are parsed
+func (v Value) Float() (float64, error) {
+	var f float64
+
+	if err := json.Unmarshal(v.raw, &f); err == nil {
+		return f, nil
+	}
+
+	return strconv.ParseFloat(v.String(), 64)
+}
+
+// Bool returns the value as a bool, booleans stored as strings are parsed
+func (v Value) Bool() (bool, error) {
+	var b bool
+
+	if err := json.Unmarshal(v.raw, &b); err == nil {
+		return b, nil
+	}
+
+	return strconv.ParseBool(v.String())
+}
+
+// Decode will JSON decode the value into out
+func (v Value) Decode(out interface{}) error {
+	return json.Unmarshal(v.raw, out)
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2/google"
@@ -42,8 +43,9 @@ type Response struct {
 			Name        string `json:"name"`
 			DisplayName string `json:"displayName"`
 		} `json:"intent"`
-		IntentDetectionConfidence float64 `json:"intentDetectionConfidence"`
-		LanguageCode              string  `json:"languageCode"`
+		OutputContexts            []Context `json:"outputContexts"`
+		IntentDetectionConfidence float64   `json:"intentDetectionConfidence"`
+		LanguageCode              string    `json:"languageCode"`
 	} `json:"queryResult"`
 }
 
@@ -112,16 +114,57 @@ func (d *API) Query(config *QueryConfig, sessionID string) (*Response, error) {
 
 // QueryText will query dialogflow with a query string
 func (d *API) QueryText(text, sessionID string) (*Response, error) {
+	return d.QueryTextContexts(text, sessionID, nil)
+}
+
+// QueryTextContexts will query dialogflow with a query string and a set of active contexts.
+// Context names can be short names, they are expanded to the full session path. The names
+// of the response's output contexts are shortened back
+func (d *API) QueryTextContexts(text, sessionID string, contexts []Context) (*Response, error) {
 	if len(text) > 255 {
 		text = string([]rune(text)[:255])
 	}
 
-	return d.Query(&QueryConfig{
+	config := &QueryConfig{
 		QueryInput: &QueryInput{
 			Text: &Text{
 				Text:         text,
 				LanguageCode: "en-US",
 			},
 		},
-	}, sessionID)
+	}
+
+	if len(contexts) > 0 {
+		config.QueryParams = &QueryParams{}
+
+		for _, ctx := range contexts {
+			ctx.Name = d.contextPath(sessionID, ctx.Name)
+			config.QueryParams.Contexts = append(config.QueryParams.Contexts, ctx)
+		}
+	}
+
+	res, err := d.Query(config, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range res.QueryResult.OutputContexts {
+		res.QueryResult.OutputContexts[i].Name = ContextName(res.QueryResult.OutputContexts[i].Name)
+	}
+
+	return res, nil
+}
+
+// contextPath returns the full resource name of a context in the session
+func (d *API) contextPath(sessionID, name string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+
+	return fmt.Sprintf("projects/%s/agent/sessions/%s/contexts/%s", d.config.ProjectID, sessionID, name)
+}
+
+// ContextName returns the short name of a context from its full resource name
+func ContextName(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
 }
@@ -1,12 +1,20 @@
 package gobbldflow
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 
 	"github.com/calebhiebert/gobbl"
 )
 
+// contextsFlagName is the flag that can hold contexts to send with the query, it can
+// be set by earlier middleware as a []Context or anything that encodes to the same JSON
+var contextsFlagName = "dflow:contexts"
+
+// outputContextsFlagName is the flag the response's output contexts are set on
+var outputContextsFlagName = "dflow:outputContexts"
+
 // Middleware will return a gobbl compatable middleware
 func Middleware(dflow *API) gbl.MiddlewareFunction {
 	return func(c *gbl.Context) {
@@ -18,7 +26,12 @@ func Middleware(dflow *API) gbl.MiddlewareFunction {
 			sessionID = fmt.Sprintf("%f", rand.Float64())
 		}
 
-		res, err := dflow.QueryText(c.Request.Text, sessionID)
+		contexts, err := flagContexts(c)
+		if err != nil {
+			c.Errorf("DFLOW invalid %s flag %v", contextsFlagName, err)
+		}
+
+		res, err := dflow.QueryTextContexts(c.Request.Text, sessionID, contexts)
 		if err != nil {
 			c.Errorf("DFLOW %v", err)
 			c.Next()
@@ -26,6 +39,7 @@ func Middleware(dflow *API) gbl.MiddlewareFunction {
 		}
 
 		c.Flag("dflow", res)
+		c.Flag(outputContextsFlagName, res.QueryResult.OutputContexts)
 
 		if res.QueryResult.IntentDetectionConfidence >= dflow.config.MinimumConfidence {
 			c.Flag("intent", res.QueryResult.Intent.DisplayName)
@@ -39,3 +53,28 @@ func Middleware(dflow *API) gbl.MiddlewareFunction {
 		c.Next()
 	}
 }
+
+// flagContexts returns the contexts set on the contexts flag
+func flagContexts(c *gbl.Context) ([]Context, error) {
+	if !c.HasFlag(contextsFlagName) {
+		return nil, nil
+	}
+
+	if contexts, ok := c.GetFlag(contextsFlagName).([]Context); ok {
+		return contexts, nil
+	}
+
+	jsonBytes, err := json.Marshal(c.GetFlag(contextsFlagName))
+	if err != nil {
+		return nil, err
+	}
+
+	var contexts []Context
+
+	err = json.Unmarshal(jsonBytes, &contexts)
+	if err != nil {
+		return nil, err
+	}
+
+	return contexts, nil
+}
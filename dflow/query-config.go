@@ -30,9 +30,9 @@ type QueryParams struct {
 }
 
 type Context struct {
-	Name          string `json:"name"`
-	LifespanCount int    `json:"lifespanCount,omitempty"`
-	Parameters    map[string]interface{}
+	Name          string                 `json:"name"`
+	LifespanCount int                    `json:"lifespanCount,omitempty"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
 }
 
 type GeoLocation struct {
@@ -0,0 +1,122 @@
+package sess
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+// ErrLockTimeout is the error that will be returned when a request waited too long
+// for the previous requests of the same user to finish
+var ErrLockTimeout = errors.New("Timed out waiting for the user's previous requests")
+
+// Locker is the interface that should be implemented by anything that can make
+// requests for the same user wait for each other
+type Locker interface {
+
+	/*
+		Waits until every earlier request for the id has released its lock,
+		requests get the lock in the order they called Lock
+		Returns a ErrLockTimeout error if the lock was not acquired within the timeout
+		The returned function releases the lock
+	*/
+	Lock(id string, timeout time.Duration) (func(), error)
+}
+
+// LockMiddleware creates a middleware that runs the requests of each user one at a time,
+// in the order they arrived. It should be used before the session middleware.
+// Requests that wait longer than the timeout are dropped, a timeout of 0 waits forever
+func LockMiddleware(locker Locker, timeout time.Duration) gbl.MiddlewareFunction {
+	return func(c *gbl.Context) {
+		unlock, err := locker.Lock(c.User.ID, timeout)
+		if err != nil {
+			c.Errorf("Request dropped, could not lock the user %v", err)
+			return
+		}
+
+		defer unlock()
+
+		c.Next()
+	}
+}
+
+type keyedMutex struct {
+	queues map[string][]chan struct{}
+	mutex  *sync.Mutex
+}
+
+// KeyedMutex creates a locker that serializes requests within this process
+func KeyedMutex() *keyedMutex {
+	return &keyedMutex{
+		queues: make(map[string][]chan struct{}),
+		mutex:  &sync.Mutex{},
+	}
+}
+
+// Lock joins the queue for the id and waits to reach the front of it
+func (k *keyedMutex) Lock(id string, timeout time.Duration) (func(), error) {
+	turn := make(chan struct{}, 1)
+
+	k.mutex.Lock()
+	k.queues[id] = append(k.queues[id], turn)
+	if len(k.queues[id]) == 1 {
+		turn <- struct{}{}
+	}
+	k.mutex.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-turn:
+		return k.unlocker(id), nil
+	case <-expired:
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	queue := k.queues[id]
+
+	// The lock was handed over at the same time as the timeout
+	if queue[0] == turn {
+		<-turn
+		return k.unlocker(id), nil
+	}
+
+	for i := range queue {
+		if queue[i] == turn {
+			k.queues[id] = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+
+	return nil, ErrLockTimeout
+}
+
+// unlocker returns the function that passes the lock to the next request in the queue
+func (k *keyedMutex) unlocker(id string) func() {
+	once := &sync.Once{}
+
+	return func() {
+		once.Do(func() {
+			k.mutex.Lock()
+			defer k.mutex.Unlock()
+
+			queue := k.queues[id][1:]
+			if len(queue) == 0 {
+				delete(k.queues, id)
+				return
+			}
+
+			k.queues[id] = queue
+			queue[0] <- struct{}{}
+		})
+	}
+}
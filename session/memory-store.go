@@ -3,8 +3,10 @@ package sess
 import "sync"
 
 type memoryStore struct {
-	sessions map[string]map[string]interface{}
-	mutex    *sync.Mutex
+	sessions  map[string]map[string]interface{}
+	revisions map[string]int64
+	revision  int64
+	mutex     *sync.Mutex
 }
 
 // MemoryStore creates a new memory session store
@@ -13,13 +15,14 @@ func MemoryStore() *memoryStore {
 		mutex: &sync.Mutex{},
 	}
 	ms.sessions = make(map[string]map[string]interface{})
+	ms.revisions = make(map[string]int64)
 	return &ms
 }
 
 // Create adds a new entry to the session map
 func (m *memoryStore) Create(id string, data *map[string]interface{}) error {
 	m.mutex.Lock()
-	m.sessions[id] = *data
+	m.set(id, data)
 	m.mutex.Unlock()
 	return nil
 }
@@ -46,6 +49,41 @@ func (m *memoryStore) Update(id string, data *map[string]interface{}) error {
 func (m *memoryStore) Destroy(id string) error {
 	m.mutex.Lock()
 	delete(m.sessions, id)
+	delete(m.revisions, id)
 	m.mutex.Unlock()
 	return nil
 }
+
+// GetVersioned returns the session and its revision from the session map
+func (m *memoryStore) GetVersioned(id string) (map[string]interface{}, int64, error) {
+	m.mutex.Lock()
+	session, ok := m.sessions[id]
+	revision := m.revisions[id]
+	m.mutex.Unlock()
+	if !ok {
+		return nil, 0, ErrSessionNonexistant
+	}
+
+	return session, revision, nil
+}
+
+// UpdateIf replaces the entry in the session map if the revision matches
+func (m *memoryStore) UpdateIf(id string, data *map[string]interface{}, revision int64) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.revisions[id] != revision {
+		return 0, ErrConflict
+	}
+
+	return m.set(id, data), nil
+}
+
+// set stores the session with a new revision, the mutex must be held.
+// Revisions are never reused, even after a session is destroyed
+func (m *memoryStore) set(id string, data *map[string]interface{}) int64 {
+	m.revision++
+	m.sessions[id] = *data
+	m.revisions[id] = m.revision
+	return m.revision
+}
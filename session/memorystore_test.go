@@ -1,6 +1,10 @@
 package sess
 
-import "testing"
+import (
+	"testing"
+
+	gblsess "github.com/calebhiebert/gobbl/session"
+)
 
 func TestGet(t *testing.T) {
 	var sess = MemoryStore()
@@ -9,6 +13,10 @@ func TestGet(t *testing.T) {
 	if err != ErrSessionNonexistant {
 		t.Error("Get is not returning an error for empty sessions")
 	}
+
+	if err != gblsess.ErrSessionNonexistant {
+		t.Error("Get should return the same error as gobbl's session stores")
+	}
 }
 
 func TestCreate(t *testing.T) {
@@ -0,0 +1,159 @@
+package gobblredis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	extsess "github.com/calebhiebert/gobbl-extra/session"
+	"github.com/go-redis/redis"
+)
+
+// errLockLost is returned when a request's place in the queue expired while it was waiting
+var errLockLost = errors.New("lock queue entry expired while waiting")
+
+// acquireScript removes expired entries from the front of the queue and
+// returns 1 if the token is at the front, 0 if it is waiting and -1 if it is gone
+var acquireScript = redis.NewScript(`
+while true do
+	local head = redis.call("LINDEX", KEYS[1], 0)
+	if not head then
+		return -1
+	end
+
+	if head == ARGV[1] then
+		return 1
+	end
+
+	if redis.call("EXISTS", ARGV[2] .. head) == 1 then
+		return 0
+	end
+
+	redis.call("LPOP", KEYS[1])
+end
+`)
+
+// releaseScript removes the token from the queue
+var releaseScript = redis.NewScript(`
+redis.call("LREM", KEYS[1], 1, ARGV[1])
+redis.call("DEL", ARGV[2] .. ARGV[1])
+return 1
+`)
+
+// RedisLocker serializes requests for the same user across every process sharing the redis database
+type RedisLocker struct {
+	client     *redis.Client
+	keyPrefix  string
+	lockExpiry time.Duration
+
+	// PollInterval is how often a waiting request checks if it has reached the front of the queue
+	PollInterval time.Duration
+}
+
+// Locker creates a locker that uses the store's redis database. Requests wait in a queue
+// for each user, the place of a request in the queue is kept alive while the process is
+// running and expires after lockExpiry if the process dies. If lockExpiry is 0, 10 seconds is used
+func (r *RedisStore) Locker(lockExpiry time.Duration) *RedisLocker {
+	if lockExpiry <= 0 {
+		lockExpiry = 10 * time.Second
+	}
+
+	return &RedisLocker{
+		client:       r.client,
+		keyPrefix:    r.keyPrefix,
+		lockExpiry:   lockExpiry,
+		PollInterval: 25 * time.Millisecond,
+	}
+}
+
+// Lock joins the queue for the id and waits to reach the front of it
+func (l *RedisLocker) Lock(id string, timeout time.Duration) (func(), error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	queueKey := l.keyPrefix + id + ":queue"
+	tokenPrefix := l.keyPrefix + id + ":lock:"
+
+	_, err = l.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(tokenPrefix+token, 1, l.lockExpiry)
+		pipe.RPush(queueKey, token)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	release := func() {
+		releaseScript.Run(l.client, []string{queueKey}, token, tokenPrefix)
+	}
+
+	started := time.Now()
+
+	for {
+		acquired, err := acquireScript.Run(l.client, []string{queueKey}, token, tokenPrefix).Int64()
+		if err == nil && acquired == -1 {
+			err = errLockLost
+		}
+
+		if err != nil {
+			release()
+			return nil, err
+		}
+
+		if acquired == 1 {
+			return l.keepAlive(tokenPrefix+token, release), nil
+		}
+
+		if timeout > 0 && time.Since(started) >= timeout {
+			release()
+			return nil, extsess.ErrLockTimeout
+		}
+
+		l.client.Expire(tokenPrefix+token, l.lockExpiry)
+		time.Sleep(l.PollInterval)
+	}
+}
+
+// keepAlive refreshes the token while the lock is held, the returned
+// function stops refreshing and releases the lock
+func (l *RedisLocker) keepAlive(tokenKey string, release func()) func() {
+	done := make(chan struct{})
+	once := &sync.Once{}
+
+	go func() {
+		ticker := time.NewTicker(l.lockExpiry / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				l.client.Expire(tokenKey, l.lockExpiry)
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() {
+			close(done)
+			release()
+		})
+	}
+}
+
+// newToken returns a random token that identifies a request in the queue
+func newToken() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
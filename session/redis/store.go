@@ -3,7 +3,7 @@ package gobblredis
 import (
 	"time"
 
-	"github.com/calebhiebert/gobbl/session"
+	extsess "github.com/calebhiebert/gobbl-extra/session"
 	"github.com/go-redis/redis"
 	"github.com/vmihailenco/msgpack"
 )
@@ -30,7 +30,12 @@ func (r *RedisStore) Create(id string, data *map[string]interface{}) error {
 		return err
 	}
 
-	_, err = r.client.Set(r.keyPrefix+id, b, r.keyExpiry).Result()
+	// The revision is bumped so versioned updates see the change
+	_, err = r.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(r.keyPrefix+id, b, r.keyExpiry)
+		r.bumpRevision(pipe, id)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
@@ -44,7 +49,7 @@ func (r *RedisStore) Get(id string) (map[string]interface{}, error) {
 	if err != nil {
 
 		if err.Error() == "redis: nil" {
-			return nil, sess.ErrSessionNonexistant
+			return nil, extsess.ErrSessionNonexistant
 		}
 
 		return nil, err
@@ -67,6 +72,6 @@ func (r *RedisStore) Update(id string, data *map[string]interface{}) error {
 
 // Destroy will completely delete the session
 func (r *RedisStore) Destroy(id string) error {
-	_, err := r.client.Del(r.keyPrefix + id).Result()
+	_, err := r.client.Del(r.keyPrefix+id, r.revisionKey(id)).Result()
 	return err
 }
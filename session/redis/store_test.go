@@ -3,7 +3,9 @@ package gobblredis
 import (
 	"reflect"
 	"testing"
+	"time"
 
+	extsess "github.com/calebhiebert/gobbl-extra/session"
 	"github.com/calebhiebert/gobbl/session"
 	"github.com/go-redis/redis"
 )
@@ -14,7 +16,7 @@ func TestGet(t *testing.T) {
 	}, 0, "session:")
 
 	_, err := sessionStore.Get("dummy-id")
-	if err != sess.ErrSessionNonexistant {
+	if err != extsess.ErrSessionNonexistant {
 		t.Error("Get is not returning an error for empty sessions")
 	}
 }
@@ -101,7 +103,7 @@ func TestUpdateCreate(t *testing.T) {
 
 	session, err := sessionStore.Get("test-id")
 	if err != nil {
-		if err != sess.ErrSessionNonexistant {
+		if err != extsess.ErrSessionNonexistant {
 			t.Error("Received error on session retrieval")
 		}
 
@@ -135,7 +137,7 @@ func TestDestroy(t *testing.T) {
 	}
 
 	_, err = sessionStore.Get("test-id")
-	if err != sess.ErrSessionNonexistant {
+	if err != extsess.ErrSessionNonexistant {
 		t.Errorf("Session get should have returned ErrSessionNonexistant, instead got %+v", err)
 	}
 }
@@ -171,3 +173,91 @@ func TestDataTypes(t *testing.T) {
 		t.Errorf("Incorrect float type, expected float64, got %v", reflect.TypeOf(s["test-float"]))
 	}
 }
+
+func TestVersioned(t *testing.T) {
+	store := New(&redis.Options{
+		Addr: "localhost:6379",
+	}, 0, "session:")
+
+	store.Destroy("test-id")
+
+	_, _, err := store.GetVersioned("test-id")
+	if err != extsess.ErrSessionNonexistant {
+		t.Errorf("Expected a missing session to return ErrSessionNonexistant, got %v", err)
+	}
+
+	revision, err := store.UpdateIf("test-id", &map[string]interface{}{"test-data": "Wow"}, 0)
+	if err != nil {
+		t.Error("Received error on versioned session creation", err)
+	}
+
+	session, current, err := store.GetVersioned("test-id")
+	if err != nil || current != revision || session["test-data"] != "Wow" {
+		t.Errorf("Expected revision %d, got %d %v %v", revision, current, session, err)
+	}
+
+	_, err = store.UpdateIf("test-id", &session, revision-1)
+	if err != extsess.ErrConflict {
+		t.Errorf("Expected a stale revision to conflict, got %v", err)
+	}
+}
+
+func TestLocker(t *testing.T) {
+	locker := New(&redis.Options{
+		Addr: "localhost:6379",
+	}, 0, "session:").Locker(time.Second)
+
+	unlock, err := locker.Lock("test-id", time.Second)
+	if err != nil {
+		t.Fatal("Received error on lock", err)
+	}
+
+	_, err = locker.Lock("test-id", 100*time.Millisecond)
+	if err != extsess.ErrLockTimeout {
+		t.Errorf("Expected the second lock to time out, got %v", err)
+	}
+
+	order := make(chan int, 2)
+	done := make(chan bool)
+
+	go func() {
+		unlock, err := locker.Lock("test-id", time.Second)
+		if err == nil {
+			order <- 2
+			unlock()
+		}
+
+		done <- true
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	order <- 1
+	unlock()
+
+	<-done
+
+	if first, second := <-order, <-order; first != 1 || second != 2 {
+		t.Errorf("Expected the waiting request to run after the lock was released, got %d %d", first, second)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	store := New(&redis.Options{
+		Addr: "localhost:6379",
+	}, time.Minute, "session:")
+
+	err := store.Create("test-id", &map[string]interface{}{"test-data": "Wow"})
+	if err != nil {
+		t.Error("Received error on session creation", err)
+	}
+
+	err = store.Touch("test-id")
+	if err != nil {
+		t.Error("Received error on session touch", err)
+	}
+
+	session, err := store.Get("test-id")
+	if err != nil || session["test-data"] != "Wow" {
+		t.Errorf("Expected the session to be unchanged, got %v %v", session, err)
+	}
+}
@@ -0,0 +1,111 @@
+package gobblredis
+
+import (
+	"strconv"
+
+	extsess "github.com/calebhiebert/gobbl-extra/session"
+	"github.com/go-redis/redis"
+	"github.com/vmihailenco/msgpack"
+)
+
+// revisionKey is the key holding the revision of a session, it is
+// incremented every time the session is written
+func (r *RedisStore) revisionKey(id string) string {
+	return r.keyPrefix + id + ":rev"
+}
+
+// bumpRevision queues the commands that increment the revision of a session
+func (r *RedisStore) bumpRevision(pipe redis.Pipeliner, id string) *redis.IntCmd {
+	incr := pipe.Incr(r.revisionKey(id))
+
+	if r.keyExpiry > 0 {
+		pipe.Expire(r.revisionKey(id), r.keyExpiry)
+	}
+
+	return incr
+}
+
+// Touch refreshes the expiry of the session without rewriting it
+func (r *RedisStore) Touch(id string) error {
+	if r.keyExpiry <= 0 {
+		return nil
+	}
+
+	_, err := r.client.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.Expire(r.keyPrefix+id, r.keyExpiry)
+		pipe.Expire(r.revisionKey(id), r.keyExpiry)
+		return nil
+	})
+
+	return err
+}
+
+// GetVersioned returns the session data and its revision from the redis store
+func (r *RedisStore) GetVersioned(id string) (map[string]interface{}, int64, error) {
+	values, err := r.client.MGet(r.keyPrefix+id, r.revisionKey(id)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, ok := values[0].(string)
+	if !ok {
+		return nil, 0, extsess.ErrSessionNonexistant
+	}
+
+	var revision int64
+
+	if rev, ok := values[1].(string); ok {
+		revision, err = strconv.ParseInt(rev, 10, 64)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var sessionData = make(map[string]interface{})
+
+	err = msgpack.Unmarshal([]byte(data), &sessionData)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return sessionData, revision, nil
+}
+
+// UpdateIf overwrites the session if it has not been written since the revision was read
+func (r *RedisStore) UpdateIf(id string, data *map[string]interface{}, revision int64) (int64, error) {
+	b, err := msgpack.Marshal(data)
+	if err != nil {
+		return 0, err
+	}
+
+	var incr *redis.IntCmd
+
+	err = r.client.Watch(func(tx *redis.Tx) error {
+		current, err := tx.Get(r.revisionKey(id)).Int64()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		if current != revision {
+			return extsess.ErrConflict
+		}
+
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.Set(r.keyPrefix+id, b, r.keyExpiry)
+			incr = r.bumpRevision(pipe, id)
+			return nil
+		})
+
+		return err
+	}, r.revisionKey(id))
+
+	if err == redis.TxFailedErr {
+		return 0, extsess.ErrConflict
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
@@ -27,3 +27,29 @@ type SessionStore interface {
 	*/
 	Destroy(id string) error
 }
+
+// Toucher is a session store that can refresh the expiry of a session without rewriting it.
+// The session middleware uses it for sessions that were not changed by the request
+type Toucher interface {
+	Touch(id string) error
+}
+
+// VersionedStore is a session store that keeps a revision of every session, so
+// updates made by requests running at the same time can be detected.
+// The session middleware uses it when the store implements it
+type VersionedStore interface {
+	SessionStore
+
+	/*
+		Returns an existing session and its revision
+		Returns a ErrSessionNonexistant error if the session does not exist
+	*/
+	GetVersioned(id string) (map[string]interface{}, int64, error)
+
+	/*
+		Overwrites the session if its revision still matches, a revision of 0 only
+		matches a session that does not exist. Returns the new revision, or a
+		ErrConflict error if the session was changed since the revision was read
+	*/
+	UpdateIf(id string, data *map[string]interface{}, revision int64) (int64, error)
+}
@@ -2,26 +2,69 @@ package sess
 
 import (
 	"errors"
+	"reflect"
 	"strings"
 
 	"github.com/calebhiebert/gobbl"
+	gblsess "github.com/calebhiebert/gobbl/session"
 )
 
-// ErrSessionNonexistant is the error that will be returned when a session does not exist
-var ErrSessionNonexistant = errors.New("Session did not exist")
+// ErrSessionNonexistant is the error that will be returned when a session does not exist.
+// It is the gobbl session package's error, so stores from this package also work with
+// code written against gobbl's session stores
+var ErrSessionNonexistant = gblsess.ErrSessionNonexistant
+
+// ErrConflict is the error that will be returned by a VersionedStore when a session
+// was changed by another request
+var ErrConflict = errors.New("Session was changed by another request")
+
+// DefaultRetries is the number of times a conflicting session update is retried
+var DefaultRetries = 3
+
+// MiddlewareConfig holds the optional settings of the session middleware
+type MiddlewareConfig struct {
+
+	// Retries is the number of times the session is merged with the latest version
+	// and saved again when it was changed by another request. Only stores that implement
+	// VersionedStore can detect changes. If Retries is 0, DefaultRetries is used
+	Retries int
+
+	// Sessions are only saved when the request added, changed or removed a key. Unchanged
+	// sessions have their expiry refreshed instead if the store is a Toucher, unless KeepTTL is set
+	KeepTTL bool
+}
 
 // Middleware creates the session middleware that will manage sessions using the
 // provided session store
 func Middleware(store SessionStore) gbl.MiddlewareFunction {
+	return MiddlewareWithConfig(store, &MiddlewareConfig{})
+}
+
+// MiddlewareWithConfig creates the session middleware using the given config.
+// If the store is a VersionedStore, keys changed by this request are merged into
+// the latest session when another request saved it first
+func MiddlewareWithConfig(store SessionStore, config *MiddlewareConfig) gbl.MiddlewareFunction {
 	return func(c *gbl.Context) {
+		versioned, isVersioned := store.(VersionedStore)
+
+		var session map[string]interface{}
+		var revision int64
+		var err error
+
+		if isVersioned {
+			session, revision, err = versioned.GetVersioned(c.User.ID)
+		} else {
+			session, err = store.Get(c.User.ID)
+		}
 
-		session, err := store.Get(c.User.ID)
 		if err != nil {
 			if err == ErrSessionNonexistant {
 				session = make(map[string]interface{})
 			}
 		}
 
+		loaded := copySession(session)
+
 		populateSessionFlags(c, session)
 
 		// Wait for the request to finish
@@ -29,13 +72,63 @@ func Middleware(store SessionStore) gbl.MiddlewareFunction {
 
 		sessionToSave := readSessionFlags(c)
 
-		err = store.Update(c.User.ID, &sessionToSave)
+		if reflect.DeepEqual(loaded, sessionToSave) {
+			config.touch(c, store)
+			return
+		}
+
+		if isVersioned {
+			err = config.saveVersioned(c, versioned, loaded, sessionToSave, revision)
+		} else {
+			err = store.Update(c.User.ID, &sessionToSave)
+		}
+
 		if err != nil {
 			c.Errorf("Error while updating the session %v", err)
 		}
 	}
 }
 
+// touch refreshes the expiry of an unchanged session
+func (config *MiddlewareConfig) touch(c *gbl.Context, store SessionStore) {
+	toucher, ok := store.(Toucher)
+	if !ok || config.KeepTTL {
+		return
+	}
+
+	err := toucher.Touch(c.User.ID)
+	if err != nil {
+		c.Errorf("Error while refreshing the session %v", err)
+	}
+}
+
+// saveVersioned saves the session, merging it into the latest session on conflicts
+func (config *MiddlewareConfig) saveVersioned(c *gbl.Context, store VersionedStore, loaded, updated map[string]interface{}, revision int64) error {
+	retries := config.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		_, err := store.UpdateIf(c.User.ID, &updated, revision)
+		if err != ErrConflict || attempt >= retries {
+			return err
+		}
+
+		c.Warnf("Session was changed by another request, merging changes")
+
+		latest, latestRevision, err := store.GetVersioned(c.User.ID)
+		if err == ErrSessionNonexistant {
+			latest = make(map[string]interface{})
+		} else if err != nil {
+			return err
+		}
+
+		updated = mergeSession(loaded, updated, latest)
+		revision = latestRevision
+	}
+}
+
 // ClearSession will clear all session variables
 func ClearSession(c *gbl.Context) {
 	flags := []string{}
@@ -67,3 +160,55 @@ func readSessionFlags(c *gbl.Context) map[string]interface{} {
 
 	return flags
 }
+
+// mergeSession applies the keys that were added, changed or removed between loaded and
+// updated to the latest session. Keys changed by both requests get the updated value
+func mergeSession(loaded, updated, latest map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{})
+
+	for k, v := range latest {
+		merged[k] = v
+	}
+
+	for k, v := range updated {
+		if previous, existed := loaded[k]; !existed || !reflect.DeepEqual(previous, v) {
+			merged[k] = v
+		}
+	}
+
+	for k := range loaded {
+		if _, exists := updated[k]; !exists {
+			delete(merged, k)
+		}
+	}
+
+	return merged
+}
+
+// copySession makes a deep copy of the session's maps and slices, so
+// changes made to them during the request can be detected
+func copySession(session map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{})
+
+	for k, v := range session {
+		copied[k] = copyValue(v)
+	}
+
+	return copied
+}
+
+func copyValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		return copySession(value)
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, item := range value {
+			copied[i] = copyValue(item)
+		}
+
+		return copied
+	default:
+		return v
+	}
+}
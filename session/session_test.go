@@ -0,0 +1,168 @@
+package sess
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/calebhiebert/gobbl"
+)
+
+func newTestContext(id string, handler gbl.MiddlewareFunction) *gbl.Context {
+	c := gbl.InputContext{}.Transform(gbl.New())
+	c.User.ID = id
+	c.Next = func() {
+		c.Next = func() {}
+		handler(c)
+	}
+
+	return c
+}
+
+func TestMiddlewareConflict(t *testing.T) {
+	store := MemoryStore()
+	middleware := Middleware(store)
+
+	store.Create("123", &map[string]interface{}{
+		"counter":   1,
+		"removed":   true,
+		"untouched": "yes",
+	})
+
+	// The second request starts and finishes while the first one is running
+	first := newTestContext("123", func(c *gbl.Context) {
+		second := newTestContext("123", func(c *gbl.Context) {
+			c.Flag("sess:counter", 2)
+			c.Flag("sess:second", true)
+		})
+
+		middleware(second)
+
+		c.Flag("sess:first", true)
+		c.ClearFlag("sess:removed")
+	})
+
+	middleware(first)
+
+	session, revision, err := store.GetVersioned("123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session["counter"] != 2 || session["first"] != true || session["second"] != true || session["untouched"] != "yes" {
+		t.Errorf("Expected the changes of both requests to be kept, got %v", session)
+	}
+
+	if _, exists := session["removed"]; exists {
+		t.Error("Expected removed keys to stay removed")
+	}
+
+	if _, err := store.UpdateIf("123", &session, revision-1); err != ErrConflict {
+		t.Errorf("Expected a stale revision to conflict, got %v", err)
+	}
+}
+
+func TestKeyedMutex(t *testing.T) {
+	locker := KeyedMutex()
+	middleware := LockMiddleware(locker, time.Second)
+
+	unlock, err := locker.Lock("123", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := locker.Lock("123", 10*time.Millisecond); err != ErrLockTimeout {
+		t.Errorf("Expected ErrLockTimeout, got %v", err)
+	}
+
+	order := make(chan int, 5)
+	done := &sync.WaitGroup{}
+
+	for i := 0; i < 5; i++ {
+		done.Add(1)
+
+		go func(i int) {
+			defer done.Done()
+			middleware(newTestContext("123", func(c *gbl.Context) {
+				order <- i
+			}))
+		}(i)
+
+		// Give each request time to join the queue
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Other users are not blocked
+	if unlockOther, err := locker.Lock("456", 10*time.Millisecond); err != nil {
+		t.Errorf("Expected another user to get the lock, got %v", err)
+	} else {
+		unlockOther()
+	}
+
+	unlock()
+	done.Wait()
+	close(order)
+
+	expected := 0
+	for i := range order {
+		if i != expected {
+			t.Errorf("Expected request %d to run next, got %d", expected, i)
+		}
+
+		expected++
+	}
+
+	if len(locker.queues) != 0 {
+		t.Errorf("Expected every queue to be removed, got %v", locker.queues)
+	}
+}
+
+type countingStore struct {
+	SessionStore
+	updates int
+	touches int
+}
+
+func (store *countingStore) Update(id string, data *map[string]interface{}) error {
+	store.updates++
+	return store.SessionStore.Update(id, data)
+}
+
+func (store *countingStore) Touch(id string) error {
+	store.touches++
+	return nil
+}
+
+func TestDirtyTracking(t *testing.T) {
+	store := &countingStore{SessionStore: MemoryStore()}
+
+	store.Create("123", &map[string]interface{}{
+		"name":  "Sam",
+		"items": []interface{}{"a"},
+	})
+
+	steps := []struct {
+		config  *MiddlewareConfig
+		handler gbl.MiddlewareFunction
+		updates int
+		touches int
+	}{
+		{&MiddlewareConfig{}, func(c *gbl.Context) {}, 0, 1},
+		{&MiddlewareConfig{KeepTTL: true}, func(c *gbl.Context) {}, 0, 1},
+		{&MiddlewareConfig{}, func(c *gbl.Context) { c.Flag("sess:name", "Sam") }, 0, 2},
+		{&MiddlewareConfig{}, func(c *gbl.Context) { c.Flag("sess:name", "Alex") }, 1, 2},
+		{&MiddlewareConfig{}, func(c *gbl.Context) { c.ClearFlag("sess:name") }, 2, 2},
+		{&MiddlewareConfig{}, func(c *gbl.Context) {
+			items := c.GetFlag("sess:items").([]interface{})
+			items[0] = "b"
+		}, 3, 2},
+	}
+
+	for i, step := range steps {
+		MiddlewareWithConfig(store, step.config)(newTestContext("123", step.handler))
+
+		if store.updates != step.updates || store.touches != step.touches {
+			t.Errorf("Step %d expected %d updates and %d touches, got %d and %d", i, step.updates, step.touches, store.updates, store.touches)
+		}
+	}
+}